@@ -8,7 +8,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -18,12 +18,18 @@ import (
 
 type Actor struct {
 	gorm.Model
-	Name        string    `json:"name"`
-	Measures    string    `json:"measures"`
-	Height      int       `json:"height"`
-	DoB         time.Time `json:"data_of_birth"`
-	Description string    `json:"description"`
-	Twitter     string    `json:"twitter"`
+	Name         string       `json:"name"`
+	Uuid         string       `json:"uuid"`
+	Measurements Measurements `json:"measurements" gorm:"column:measures;type:varchar(255)"`
+	Height       int          `json:"height"`
+	DateOfBirth  PartialDate  `json:"date_of_birth" gorm:"type:varchar(10)"`
+	Description  string       `json:"description"`
+	SocialLinks  []SocialLink `json:"social_links"`
+	Aliases      []ActorAlias `json:"aliases"`
+	PrimaryPhoto *Photo       `json:"primary_photo" gorm:"-"`
+	// version is the representation the actor is written in, set by
+	// writeJSON from the request, ActorAPIVersion when 0
+	version int
 }
 
 type GetActors struct {
@@ -35,57 +41,96 @@ var ActorsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 	limit := 100
 	page := 0
 	actors := []Actor{}
-	db.Limit(limit).Find(&actors).Offset(page * limit)
+	query := db
+	// Look actors up by any of their names
+	if name := r.URL.Query().Get("name"); name != "" {
+		name = strings.ToLower(strings.TrimSpace(name))
+		var aliased []uint
+		db.Model(&ActorAlias{}).Where("lower(name) = ?", name).Pluck("actor_id", &aliased)
+		query = query.Where("lower(name) = ? OR id IN (?)", name, aliased)
+	}
+	query.Preload("SocialLinks").Limit(limit).Find(&actors).Offset(page * limit)
+	setPrimaryPhotos(actors)
 	nav := getNavigation(len(actors), page, limit)
 
-	response, _ := json.Marshal(GetActors{Nav: nav, Actors: actors})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+	writeJSON(w, GetActors{Nav: nav, Actors: actors})
 })
 
 var ActorGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var actor Actor
-	getActor(r, &actor)
+	if getActor(r, &actor) != nil {
+		if !redirectActor(w, r) {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	db.Model(&actor).Related(&actor.Aliases)
+	db.Model(&actor).Related(&actor.SocialLinks)
+	actors := []Actor{actor}
+	setPrimaryPhotos(actors)
 
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(actor)
-	w.Write([]byte(response))
+	writeJSON(w, actors[0])
 })
 
 var ActorsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var t Actor
 	mapActor(r, &t)
 	db.Create(&t)
-	response, _ := json.Marshal(t)
-	w.Write([]byte(response))
+	recordRevision(currentUser(r).ID, RevisionCreate, "actors", t.ID, nil, t)
+	writeJSON(w, t)
 })
 
 var ActorsPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var actor Actor
 	var updatedActor Actor
-	getActor(r, &actor)
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	mapActor(r, &updatedActor)
+	db.Model(&actor).Related(&actor.SocialLinks)
 
+	before := actor
 	actor.Name = updatedActor.Name
+	// Profile fields left out of the request are kept
+	if !updatedActor.DateOfBirth.IsZero() {
+		actor.DateOfBirth = updatedActor.DateOfBirth
+	}
+	if !updatedActor.Measurements.IsZero() {
+		actor.Measurements = updatedActor.Measurements
+	}
+	if updatedActor.Height != 0 {
+		actor.Height = updatedActor.Height
+	}
+	if updatedActor.Description != "" {
+		actor.Description = updatedActor.Description
+	}
+	if updatedActor.SocialLinks != nil {
+		db.Unscoped().Where("actor_id = ?", actor.ID).Delete(SocialLink{})
+		actor.SocialLinks = updatedActor.SocialLinks
+	}
 
 	db.Save(&actor)
-	response, _ := json.Marshal(actor)
-	w.Write([]byte(response))
+	recordRevision(currentUser(r).ID, RevisionUpdate, "actors", actor.ID, before, actor)
+	writeJSON(w, actor)
 })
 
 var ActorDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var actor Actor
-	getActor(r, &actor)
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Model(&actor).Related(&actor.SocialLinks)
 	db.Delete(&actor)
+	recordRevision(currentUser(r).ID, RevisionDelete, "actors", actor.ID, actor, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(""))
 })
 
-func getActor(r *http.Request, actor *Actor) {
-	vars := mux.Vars(r)
-	db.Find(&actor, vars["id"])
+func getActor(r *http.Request, actor *Actor) error {
+	return findByID(db, actor, mux.Vars(r)["id"])
 }
 
 func mapActor(r *http.Request, t *Actor) {
@@ -94,4 +139,14 @@ func mapActor(r *http.Request, t *Actor) {
 	if err != nil {
 		log.Println("Invalid input")
 	}
+	t.Uuid = ""
+	// Aliases and photos go through their own endpoints
+	t.Aliases = nil
+	t.PrimaryPhoto = nil
+	for i := range t.SocialLinks {
+		t.SocialLinks[i].Model = gorm.Model{}
+		t.SocialLinks[i].Uuid = ""
+		t.SocialLinks[i].ActorID = 0
+		t.SocialLinks[i].Type = strings.ToLower(strings.TrimSpace(t.SocialLinks[i].Type))
+	}
 }
@@ -0,0 +1,441 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ActorAPIVersion is the JSON representation of actors used when a request
+// does not ask for one. Version 1 is the compatibility mode, which also writes
+// the keys of the original representation (data_of_birth, measures and
+// twitter) for older clients.
+var ActorAPIVersion = getEnvInt("ACTOR_API_VERSION", 1)
+
+// actorVersionWriter carries the representation of actors asked for by a
+// request down to writeJSON
+type actorVersionWriter struct {
+	http.ResponseWriter
+	version int
+}
+
+// withActorVersions lets every request choose the representation of actors,
+// with a version query parameter (?version=2) or a version parameter of its
+// Accept header (application/json; version=2)
+func withActorVersions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(actorVersionWriter{ResponseWriter: w, version: requestActorVersion(r)}, r)
+	})
+}
+
+// requestActorVersion returns the representation of actors asked for by a
+// request, ActorAPIVersion when it does not ask for one
+func requestActorVersion(r *http.Request) int {
+	if version, err := strconv.Atoi(r.URL.Query().Get("version")); err == nil {
+		return version
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(accept); err == nil {
+			if version, err := strconv.Atoi(params["version"]); err == nil {
+				return version
+			}
+		}
+	}
+	return ActorAPIVersion
+}
+
+// actorVersion returns the representation of actors to write a response with
+func actorVersion(w http.ResponseWriter) int {
+	if vw, ok := w.(actorVersionWriter); ok {
+		return vw.version
+	}
+	return ActorAPIVersion
+}
+
+// withActorVersion returns a copy of v whose actors are written in the given
+// representation
+func withActorVersion(v interface{}, version int) interface{} {
+	if v == nil {
+		return v
+	}
+	copied := reflect.New(reflect.TypeOf(v)).Elem()
+	copied.Set(reflect.ValueOf(v))
+	setActorVersion(copied, version)
+	return copied.Interface()
+}
+
+// setActorVersion sets the representation of every actor reachable from v
+// through exported fields
+func setActorVersion(v reflect.Value, version int) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			setActorVersion(v.Elem(), version)
+		}
+	case reflect.Interface:
+		// Values held by interfaces cannot be changed in place
+		if !v.IsNil() && v.CanSet() {
+			copied := reflect.New(v.Elem().Type()).Elem()
+			copied.Set(v.Elem())
+			setActorVersion(copied, version)
+			v.Set(copied)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setActorVersion(v.Index(i), version)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(Actor{}) && v.CanAddr() {
+			v.Addr().Interface().(*Actor).version = version
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				setActorVersion(v.Field(i), version)
+			}
+		}
+	}
+}
+
+// PartialDate is a date of which only the year, or the year and month, may be
+// known. It is written as "2006", "2006-01" or "2006-01-02", and null when
+// unknown.
+type PartialDate struct {
+	Year  int
+	Month int
+	Day   int
+}
+
+var partialDatePattern = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2}))?)?$`)
+
+// ParsePartialDate parses a partial date, or an RFC 3339 time of which only
+// the date is kept
+func ParsePartialDate(s string) (PartialDate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return PartialDate{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return dateOf(t), nil
+	}
+	m := partialDatePattern.FindStringSubmatch(s)
+	if m == nil {
+		return PartialDate{}, fmt.Errorf("invalid date %q", s)
+	}
+	d := PartialDate{}
+	d.Year, _ = strconv.Atoi(m[1])
+	d.Month, _ = strconv.Atoi(m[2])
+	d.Day, _ = strconv.Atoi(m[3])
+	if d.Month > 12 || (d.Month > 0 && d.Day > 0 && d.Day > time.Date(d.Year, time.Month(d.Month)+1, 0, 0, 0, 0, 0, time.UTC).Day()) {
+		return PartialDate{}, fmt.Errorf("invalid date %q", s)
+	}
+	return d, nil
+}
+
+// dateOf returns the date of a time, or the unknown date for the zero time
+func dateOf(t time.Time) PartialDate {
+	if t.IsZero() {
+		return PartialDate{}
+	}
+	return PartialDate{Year: t.Year(), Month: int(t.Month()), Day: t.Day()}
+}
+
+func (d PartialDate) IsZero() bool {
+	return d.Year == 0
+}
+
+func (d PartialDate) String() string {
+	switch {
+	case d.Year == 0:
+		return ""
+	case d.Month == 0:
+		return fmt.Sprintf("%04d", d.Year)
+	case d.Day == 0:
+		return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// Time returns the first day the partial date can be, the zero time when it
+// is unknown
+func (d PartialDate) Time() time.Time {
+	if d.Year == 0 {
+		return time.Time{}
+	}
+	month, day := d.Month, d.Day
+	if month == 0 {
+		month = 1
+	}
+	if day == 0 {
+		day = 1
+	}
+	return time.Date(d.Year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// AgeAt returns the age of someone born on the date at the given time. When
+// only part of the date is known it returns the lowest possible age. The
+// second value is false when the date is unknown.
+func (d PartialDate) AgeAt(now time.Time) (int, bool) {
+	if d.Year == 0 {
+		return 0, false
+	}
+	age := now.Year() - d.Year
+	month, day := d.Month, d.Day
+	if month == 0 {
+		month = 12
+	}
+	if day == 0 {
+		day = 31
+	}
+	if int(now.Month()) < month || (int(now.Month()) == month && now.Day() < day) {
+		age--
+	}
+	return age, true
+}
+
+func (d PartialDate) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+func (d *PartialDate) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == nil {
+		*d = PartialDate{}
+		return nil
+	}
+	parsed, err := ParsePartialDate(*s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d PartialDate) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+func (d *PartialDate) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = PartialDate{}
+		return nil
+	case time.Time:
+		*d = dateOf(v)
+		return nil
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		parsed, err := ParsePartialDate(v)
+		*d = parsed
+		return err
+	}
+	return fmt.Errorf("cannot scan %T into a date", value)
+}
+
+// Measurements are the body measurements of an actor, stored in the measures
+// column as "34C-24-34". Values that cannot be parsed are kept in Raw.
+type Measurements struct {
+	Bust  int    `json:"bust,omitempty"`
+	Cup   string `json:"cup,omitempty"`
+	Waist int    `json:"waist,omitempty"`
+	Hips  int    `json:"hips,omitempty"`
+	Raw   string `json:"-"`
+}
+
+var measurementsPattern = regexp.MustCompile(`^(\d+)\s*([A-Za-z]*)\s*[-/x]\s*(\d+)\s*[-/x]\s*(\d+)$`)
+
+// ParseMeasurements parses measurements written as bust and cup, waist and
+// hips separated by dashes
+func ParseMeasurements(s string) Measurements {
+	s = strings.TrimSpace(s)
+	m := measurementsPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Measurements{Raw: s}
+	}
+	bust, _ := strconv.Atoi(m[1])
+	waist, _ := strconv.Atoi(m[3])
+	hips, _ := strconv.Atoi(m[4])
+	return Measurements{Bust: bust, Cup: strings.ToUpper(m[2]), Waist: waist, Hips: hips}
+}
+
+func (m Measurements) IsZero() bool {
+	return m.Bust == 0 && m.Waist == 0 && m.Hips == 0 && m.Cup == "" && m.Raw == ""
+}
+
+func (m Measurements) String() string {
+	if m.Bust == 0 && m.Waist == 0 && m.Hips == 0 && m.Cup == "" {
+		return m.Raw
+	}
+	return fmt.Sprintf("%d%s-%d-%d", m.Bust, m.Cup, m.Waist, m.Hips)
+}
+
+func (m Measurements) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Measurements) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = Measurements{}
+	case []byte:
+		*m = ParseMeasurements(string(v))
+	case string:
+		*m = ParseMeasurements(v)
+	default:
+		return fmt.Errorf("cannot scan %T into measurements", value)
+	}
+	return nil
+}
+
+// SocialLink is a profile of an actor on another site, Type naming the site
+// (twitter, instagram, website...)
+type SocialLink struct {
+	gorm.Model
+	Uuid    string `json:"uuid"`
+	ActorID uint   `json:"actor_id" gorm:"index"`
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+}
+
+// twitterURL turns a Twitter handle into the URL of the profile
+func twitterURL(handle string) string {
+	handle = strings.TrimSpace(handle)
+	if handle == "" || strings.Contains(handle, "/") {
+		return handle
+	}
+	return "https://twitter.com/" + strings.TrimPrefix(handle, "@")
+}
+
+// actorFields has the fields of Actor without its JSON methods
+type actorFields Actor
+
+func (a Actor) MarshalJSON() ([]byte, error) {
+	out := struct {
+		actorFields
+		Age         *int       `json:"age"`
+		DataOfBirth *time.Time `json:"data_of_birth,omitempty"`
+		Measures    *string    `json:"measures,omitempty"`
+		Twitter     *string    `json:"twitter,omitempty"`
+	}{actorFields: actorFields(a)}
+	if age, ok := a.DateOfBirth.AgeAt(time.Now()); ok {
+		out.Age = &age
+	}
+	if out.SocialLinks == nil {
+		out.SocialLinks = []SocialLink{}
+	}
+
+	version := a.version
+	if version == 0 {
+		version = ActorAPIVersion
+	}
+	if version >= 2 {
+		return json.Marshal(out)
+	}
+	dob := a.DateOfBirth.Time()
+	measures := a.Measurements.String()
+	twitter := ""
+	for _, link := range a.SocialLinks {
+		if link.Type == "twitter" {
+			twitter = link.URL
+			break
+		}
+	}
+	out.DataOfBirth = &dob
+	out.Measures = &measures
+	out.Twitter = &twitter
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads both representations of actors, the keys of the
+// original one filling the new fields left empty
+func (a *Actor) UnmarshalJSON(data []byte) error {
+	in := struct {
+		*actorFields
+		DataOfBirth *time.Time `json:"data_of_birth"`
+		Measures    *string    `json:"measures"`
+		Twitter     *string    `json:"twitter"`
+	}{actorFields: (*actorFields)(a)}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	if in.DataOfBirth != nil && a.DateOfBirth.IsZero() {
+		a.DateOfBirth = dateOf(*in.DataOfBirth)
+	}
+	if in.Measures != nil && a.Measurements.IsZero() {
+		a.Measurements = ParseMeasurements(*in.Measures)
+	}
+	if in.Twitter != nil && *in.Twitter != "" {
+		for _, link := range a.SocialLinks {
+			if link.Type == "twitter" {
+				return nil
+			}
+		}
+		a.SocialLinks = append(a.SocialLinks, SocialLink{Type: "twitter", URL: twitterURL(*in.Twitter)})
+	}
+	return nil
+}
+
+// migrateActorProfiles copies the dates of birth and Twitter accounts of the
+// original actor columns to the new fields
+func migrateActorProfiles() {
+	scope := db.NewScope(&Actor{})
+	// The original DoB field is stored in dob or do_b depending on the gorm
+	// release which created the column
+	for _, column := range []string{"dob", "do_b"} {
+		if !scope.Dialect().HasColumn(scope.TableName(), column) {
+			continue
+		}
+		rows := []struct {
+			ID   uint
+			Born *time.Time
+		}{}
+		db.Table(scope.TableName()).Select("id, " + column + " AS born").
+			Where(column + " IS NOT NULL AND (date_of_birth IS NULL OR date_of_birth = '')").Scan(&rows)
+		for _, row := range rows {
+			if row.Born != nil && row.Born.Year() > 1 {
+				db.Table(scope.TableName()).Where("id = ?", row.ID).
+					UpdateColumn("date_of_birth", dateOf(*row.Born))
+			}
+		}
+	}
+
+	// The twitter column is kept as it was, the accounts are only moved once,
+	// while there are no social links yet, so that links removed afterwards
+	// do not come back
+	var links int
+	db.Unscoped().Model(&SocialLink{}).Count(&links)
+	if links == 0 && scope.Dialect().HasColumn(scope.TableName(), "twitter") {
+		rows := []struct {
+			ID      uint
+			Twitter string
+		}{}
+		db.Table(scope.TableName()).Select("id, twitter").Where("twitter <> ''").Scan(&rows)
+		for _, row := range rows {
+			link := SocialLink{ActorID: row.ID, Type: "twitter", URL: twitterURL(row.Twitter)}
+			db.Where(SocialLink{ActorID: row.ID, Type: "twitter"}).FirstOrCreate(&link)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartialDate(t *testing.T) {
+	Convey("Given partial dates", t, func() {
+		now := time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC)
+
+		Convey("Then full, year-month and year-only dates should parse", func() {
+			for _, s := range []string{"1990-07-20", "1990-07", "1990"} {
+				d, err := ParsePartialDate(s)
+				So(err, ShouldBeNil)
+				So(d.String(), ShouldEqual, s)
+			}
+		})
+
+		Convey("Then invalid dates should be refused", func() {
+			_, err := ParsePartialDate("1990-02-30")
+			So(err, ShouldNotBeNil)
+			_, err = ParsePartialDate("sometime")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the age should be exact for full dates", func() {
+			before, _ := ParsePartialDate("1990-06-15")
+			after, _ := ParsePartialDate("1990-06-16")
+			age, _ := before.AgeAt(now)
+			So(age, ShouldEqual, 27)
+			age, _ = after.AgeAt(now)
+			So(age, ShouldEqual, 26)
+		})
+
+		Convey("Then the age should be the lowest possible for a year", func() {
+			d, _ := ParsePartialDate("1990")
+			age, ok := d.AgeAt(now)
+			So(ok, ShouldBeTrue)
+			So(age, ShouldEqual, 26)
+		})
+
+		Convey("Then an unknown date should have no age", func() {
+			_, ok := PartialDate{}.AgeAt(now)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestMeasurements(t *testing.T) {
+	Convey("Given measurements written as a string", t, func() {
+		m := ParseMeasurements("34c-24-35")
+
+		Convey("Then they should be structured", func() {
+			So(m, ShouldResemble, Measurements{Bust: 34, Cup: "C", Waist: 24, Hips: 35})
+			So(m.String(), ShouldEqual, "34C-24-35")
+		})
+
+		Convey("Then unknown formats should be kept as is", func() {
+			So(ParseMeasurements("curvy").String(), ShouldEqual, "curvy")
+		})
+	})
+}
+
+func TestActorRepresentation(t *testing.T) {
+	defer func(version int) { ActorAPIVersion = version }(ActorAPIVersion)
+
+	Convey("Given an actor posted with the original keys", t, func() {
+		setupTestSuite()
+		ActorAPIVersion = 1
+		body := `{"name": "Jane", "data_of_birth": "1990-07-20T00:00:00Z", "measures": "34C-24-35", "twitter": "@jane"}`
+		response := doRequest("POST", "/actors", bytes.NewBufferString(body))
+		actor := Actor{}
+		json.Unmarshal(response.Body.Bytes(), &actor)
+		id := fmt.Sprint(actor.ID)
+
+		Convey("Then the new fields should be filled", func() {
+			a := Actor{}
+			db.Preload("SocialLinks").First(&a, actor.ID)
+			So(a.DateOfBirth.String(), ShouldEqual, "1990-07-20")
+			So(a.Measurements.Bust, ShouldEqual, 34)
+			So(len(a.SocialLinks), ShouldEqual, 1)
+			So(a.SocialLinks[0].URL, ShouldEqual, "https://twitter.com/jane")
+		})
+
+		Convey("When I read it in compatibility mode", func() {
+			fields := map[string]interface{}{}
+			json.Unmarshal(doRequest("GET", "/actors/"+id, nil).Body.Bytes(), &fields)
+
+			Convey("Then both representations should be written", func() {
+				So(fields["date_of_birth"], ShouldEqual, "1990-07-20")
+				So(fields["data_of_birth"], ShouldEqual, "1990-07-20T00:00:00Z")
+				So(fields["measures"], ShouldEqual, "34C-24-35")
+				So(fields["twitter"], ShouldEqual, "https://twitter.com/jane")
+				So(fields["age"], ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I read it with the second version", func() {
+			fields := map[string]interface{}{}
+			json.Unmarshal(doRequest("GET", "/actors/"+id+"?version=2", nil).Body.Bytes(), &fields)
+
+			Convey("Then the original keys should be left out", func() {
+				So(fields, ShouldNotContainKey, "data_of_birth")
+				So(fields, ShouldNotContainKey, "measures")
+				So(fields, ShouldNotContainKey, "twitter")
+				So(fields["measurements"], ShouldResemble, map[string]interface{}{"bust": 34.0, "cup": "C", "waist": 24.0, "hips": 35.0})
+			})
+		})
+
+		Convey("When I list actors with the second version", func() {
+			listed := map[string][]map[string]interface{}{}
+			json.Unmarshal(doRequest("GET", "/actors?version=2", nil).Body.Bytes(), &listed)
+			doRequest("DELETE", "/actors/"+id, nil)
+			trashed := map[string][]map[string]interface{}{}
+			json.Unmarshal(doRequestAs(User{Name: "editor", Role: RoleEditor}, "GET", "/trash/actors?version=2", nil).Body.Bytes(), &trashed)
+
+			Convey("Then the nested actors should leave the original keys out", func() {
+				So(len(listed["actors"]), ShouldEqual, 1)
+				So(listed["actors"][0], ShouldNotContainKey, "measures")
+				So(len(trashed["items"]), ShouldEqual, 1)
+				So(trashed["items"][0], ShouldNotContainKey, "measures")
+				So(trashed["items"][0], ShouldContainKey, "measurements")
+			})
+		})
+
+		Convey("When I ask for the second version in the Accept header", func() {
+			request, _ := http.NewRequest("GET", "/actors/"+id, nil)
+			request.Header.Set("Authorization", "Bearer "+string(getToken(User{Name: "me"})))
+			request.Header.Set("Accept", "application/json; version=2")
+			response := httptest.NewRecorder()
+			setupRouter().ServeHTTP(response, request)
+			fields := map[string]interface{}{}
+			json.Unmarshal(response.Body.Bytes(), &fields)
+
+			Convey("Then the original keys should be left out", func() {
+				So(fields, ShouldNotContainKey, "measures")
+				So(fields["date_of_birth"], ShouldEqual, "1990-07-20")
+			})
+		})
+
+		Convey("When the second version is the default and I ask for the first one", func() {
+			ActorAPIVersion = 2
+			withDefault := map[string]interface{}{}
+			json.Unmarshal(doRequest("GET", "/actors/"+id, nil).Body.Bytes(), &withDefault)
+			fields := map[string]interface{}{}
+			json.Unmarshal(doRequest("GET", "/actors/"+id+"?version=1", nil).Body.Bytes(), &fields)
+
+			Convey("Then only my request should get the original keys", func() {
+				So(withDefault, ShouldNotContainKey, "measures")
+				So(fields["measures"], ShouldEqual, "34C-24-35")
+			})
+		})
+
+		Convey("When I only send a year of birth", func() {
+			doRequest("PATCH", "/actors/"+id, bytes.NewBufferString(`{"name": "Jane", "date_of_birth": "1991"}`))
+
+			Convey("Then the partial date should be stored and other fields kept", func() {
+				a := Actor{}
+				db.First(&a, actor.ID)
+				So(a.DateOfBirth, ShouldResemble, PartialDate{Year: 1991})
+				So(a.Measurements.Waist, ShouldEqual, 24)
+			})
+		})
+	})
+
+	Convey("Given actors stored with the original columns", t, func() {
+		setupTestSuite()
+		scope := db.NewScope(&Actor{})
+		if !scope.Dialect().HasColumn("actors", "do_b") {
+			db.Exec("ALTER TABLE actors ADD COLUMN do_b datetime")
+		}
+		if !scope.Dialect().HasColumn("actors", "twitter") {
+			db.Exec("ALTER TABLE actors ADD COLUMN twitter varchar(255)")
+		}
+		actor := Actor{Name: "Jane"}
+		db.Create(&actor)
+		db.Exec("UPDATE actors SET do_b = ?, twitter = ? WHERE id = ?",
+			time.Date(1985, 4, 12, 0, 0, 0, 0, time.UTC), "janedoe", actor.ID)
+
+		Convey("When the profiles are migrated", func() {
+			migrateActorProfiles()
+
+			Convey("Then the date of birth and Twitter account should be moved", func() {
+				a := Actor{}
+				db.Preload("SocialLinks").First(&a, actor.ID)
+				So(a.DateOfBirth.String(), ShouldEqual, "1985-04-12")
+				So(len(a.SocialLinks), ShouldEqual, 1)
+				So(a.SocialLinks[0].URL, ShouldEqual, "https://twitter.com/janedoe")
+			})
+
+			Convey("Then the original column should be kept", func() {
+				var twitter string
+				db.Table("actors").Where("id = ?", actor.ID).Select("twitter").Row().Scan(&twitter)
+				So(twitter, ShouldEqual, "janedoe")
+			})
+
+			Convey("When a migrated link is removed and the profiles are migrated again", func() {
+				db.Unscoped().Where("actor_id = ?", actor.ID).Delete(SocialLink{})
+				db.Create(&SocialLink{ActorID: actor.ID, Type: "website", URL: "https://jane.example"})
+				migrateActorProfiles()
+
+				Convey("Then it should not come back", func() {
+					var n int
+					db.Model(&SocialLink{}).Where("actor_id = ? AND type = ?", actor.ID, "twitter").Count(&n)
+					So(n, ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// ActorAlias is another spelling of the name of an actor
+type ActorAlias struct {
+	gorm.Model
+	Uuid    string `json:"uuid"`
+	ActorID uint   `json:"actor_id" gorm:"index"`
+	Name    string `json:"name"`
+}
+
+// ActorRedirect points the IDs of an actor merged into another one to the
+// actor it was merged into
+type ActorRedirect struct {
+	gorm.Model
+	FromID   uint   `json:"from_id" gorm:"unique_index"`
+	FromUuid string `json:"from_uuid" gorm:"index"`
+	ToID     uint   `json:"to_id" gorm:"index"`
+}
+
+// ActorMerge is the body of a merge request, naming the duplicate by ID or
+// UUID
+type ActorMerge struct {
+	Duplicate string `json:"duplicate"`
+}
+
+var ActorAliasesPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t ActorAlias
+	mapActorAlias(r, &t)
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		http.Error(w, "Alias name is empty", http.StatusBadRequest)
+		return
+	}
+	if other, err := findActorByName(t.Name); err == nil {
+		if other.ID == actor.ID {
+			http.Error(w, "Actor already goes by this name", http.StatusConflict)
+		} else {
+			http.Error(w, fmt.Sprintf("Name already used by actor %d", other.ID), http.StatusConflict)
+		}
+		return
+	}
+
+	t.ActorID = actor.ID
+	db.Create(&t)
+
+	writeJSONStatus(w, http.StatusCreated, t)
+})
+
+var ActorAliasDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	var alias ActorAlias
+	if getActor(r, &actor) != nil ||
+		findByID(db.Where("actor_id = ?", actor.ID), &alias, mux.Vars(r)["alias"]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Unscoped().Delete(&alias)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+var ActorMergeHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor, duplicate Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t ActorMerge
+	mapActorMerge(r, &t)
+	if findByID(db, &duplicate, t.Duplicate) != nil {
+		http.Error(w, "Duplicate actor not found", http.StatusUnprocessableEntity)
+		return
+	}
+	if duplicate.ID == actor.ID {
+		http.Error(w, "Cannot merge an actor into itself", http.StatusUnprocessableEntity)
+		return
+	}
+
+	moved, err := mergeActors(actor, duplicate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if moved.Documents > 0 || moved.Record {
+		auditCompliance(r, "merge", "actors", actor.ID, moved)
+	}
+	recordRevision(currentUser(r).ID, RevisionDelete, "actors", duplicate.ID, duplicate, nil)
+	invalidateCostarGraph()
+
+	db.Preload("Aliases").First(&actor, actor.ID)
+	writeJSON(w, actor)
+})
+
+// mergeActors moves the videos, markers, aliases, social links and photos of a
+// duplicate onto the canonical actor, keeps the name of the duplicate as an
+// alias, deletes the duplicate and redirects its IDs to the canonical actor.
+// It tells which compliance records were moved, for the audit log.
+func mergeActors(actor, duplicate Actor) (ComplianceMerge, error) {
+	moved := ComplianceMerge{Duplicate: duplicate.ID}
+	tx := db.Begin()
+	fail := func(err error) (ComplianceMerge, error) {
+		tx.Rollback()
+		return moved, err
+	}
+	// Compliance records are only moved when the actor has none, else they
+	// stay with the duplicate
+	documents := tx.Model(&ComplianceDocument{}).Where("actor_id = ?", duplicate.ID).UpdateColumn("actor_id", actor.ID)
+	if documents.Error != nil {
+		return fail(documents.Error)
+	}
+	moved.Documents = documents.RowsAffected
+	record := tx.Exec("UPDATE compliance_records SET actor_id = ? WHERE actor_id = ? AND "+
+		"NOT EXISTS (SELECT 1 FROM compliance_records WHERE actor_id = ?)", actor.ID, duplicate.ID, actor.ID)
+	if record.Error != nil {
+		return fail(record.Error)
+	}
+	moved.Record = record.RowsAffected > 0
+
+	// Every step runs once the previous one succeeded
+	steps := []func() *gorm.DB{
+		func() *gorm.DB {
+			return tx.Exec("INSERT INTO video_actors (video_id, actor_id) SELECT video_id, ? FROM video_actors "+
+				"WHERE actor_id = ? AND video_id NOT IN (SELECT video_id FROM video_actors WHERE actor_id = ?)",
+				actor.ID, duplicate.ID, actor.ID)
+		},
+		func() *gorm.DB { return tx.Exec("DELETE FROM video_actors WHERE actor_id = ?", duplicate.ID) },
+		func() *gorm.DB {
+			return tx.Model(&Marker{}).Where("actor_id = ?", duplicate.ID).UpdateColumn("actor_id", actor.ID)
+		},
+		func() *gorm.DB {
+			return tx.Model(&ActorAlias{}).Where("actor_id = ?", duplicate.ID).UpdateColumn("actor_id", actor.ID)
+		},
+		func() *gorm.DB {
+			return tx.Model(&SocialLink{}).Where("actor_id = ?", duplicate.ID).UpdateColumn("actor_id", actor.ID)
+		},
+		// The photos of the duplicate go after the ones of the actor, which
+		// keeps its primary photo when it has one
+		func() *gorm.DB {
+			return tx.Model(&Photo{}).Where("actor_id = ? AND EXISTS (SELECT 1 FROM photos WHERE actor_id = ? AND is_primary = ?)",
+				duplicate.ID, actor.ID, true).UpdateColumn("is_primary", false)
+		},
+		func() *gorm.DB {
+			return tx.Exec("UPDATE photos SET position = position + (SELECT count(*) FROM photos WHERE actor_id = ?) WHERE actor_id = ?",
+				actor.ID, duplicate.ID)
+		},
+		func() *gorm.DB {
+			return tx.Model(&Photo{}).Where("actor_id = ?", duplicate.ID).UpdateColumn("actor_id", actor.ID)
+		},
+		func() *gorm.DB {
+			return tx.Model(&ActorRedirect{}).Where("to_id = ?", duplicate.ID).UpdateColumn("to_id", actor.ID)
+		},
+		func() *gorm.DB {
+			return tx.Create(&ActorRedirect{FromID: duplicate.ID, FromUuid: duplicate.Uuid, ToID: actor.ID})
+		},
+	}
+	if !strings.EqualFold(duplicate.Name, actor.Name) {
+		steps = append(steps, func() *gorm.DB { return tx.Create(&ActorAlias{ActorID: actor.ID, Name: duplicate.Name}) })
+	}
+	steps = append(steps, func() *gorm.DB { return tx.Delete(&duplicate) })
+
+	for _, step := range steps {
+		if err := step().Error; err != nil {
+			return fail(err)
+		}
+	}
+	return moved, tx.Commit().Error
+}
+
+// findActorByName returns the actor with the given name or alias, ignoring
+// case
+func findActorByName(name string) (Actor, error) {
+	var actor Actor
+	name = strings.ToLower(strings.TrimSpace(name))
+	err := db.Where("lower(name) = ?", name).First(&actor).Error
+	if err == nil {
+		return actor, nil
+	}
+	var alias ActorAlias
+	if err := db.Where("lower(name) = ?", name).First(&alias).Error; err != nil {
+		return actor, err
+	}
+	return actor, db.First(&actor, alias.ActorID).Error
+}
+
+// findActor returns the actor with the given ID, UUID, name or alias
+func findActor(value string) (Actor, error) {
+	var actor Actor
+	if findByID(db, &actor, value) == nil {
+		return actor, nil
+	}
+	return findActorByName(value)
+}
+
+// redirectActor sends a permanent redirect to the actor a merged actor was
+// merged into, telling whether the requested actor was merged
+func redirectActor(w http.ResponseWriter, r *http.Request) bool {
+	id := mux.Vars(r)["id"]
+	var redirect ActorRedirect
+	query := db.Where("from_uuid = ?", id)
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		query = db.Where("from_id = ?", n)
+	}
+	if query.First(&redirect).RecordNotFound() {
+		return false
+	}
+	var actor Actor
+	if db.First(&actor, redirect.ToID).RecordNotFound() {
+		return false
+	}
+
+	target := fmt.Sprint(actor.ID)
+	if redirect.FromUuid == id || publicUUIDsOnly() {
+		target = actor.Uuid
+	}
+	http.Redirect(w, r, "/actors/"+target, http.StatusMovedPermanently)
+	return true
+}
+
+func mapActorAlias(r *http.Request, t *ActorAlias) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Uuid = ""
+}
+
+func mapActorMerge(r *http.Request, t *ActorMerge) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
@@ -0,0 +1,187 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestActorAliases(t *testing.T) {
+	Convey("Given an actor with an alias", t, func() {
+		setupTestSuite()
+		actor := Actor{Name: "Jane Doe"}
+		db.Create(&actor)
+		id := fmt.Sprint(actor.ID)
+		response := doRequest("POST", "/actors/"+id+"/aliases", bytes.NewBufferString(`{"name": "J. Doe"}`))
+
+		Convey("Then the alias should be created", func() {
+			So(response.Code, ShouldEqual, 201)
+		})
+
+		Convey("When I add the same alias again", func() {
+			response := doRequest("POST", "/actors/"+id+"/aliases", bytes.NewBufferString(`{"name": "j. doe"}`))
+
+			Convey("Then I should get a 409 response", func() {
+				So(response.Code, ShouldEqual, 409)
+			})
+		})
+
+		Convey("When an import names the actor by the alias", func() {
+			found := findOrCreateActor("J. DOE")
+
+			Convey("Then the existing actor should be used", func() {
+				So(found.ID, ShouldEqual, actor.ID)
+			})
+		})
+
+		Convey("When I search actors by the alias", func() {
+			response := doRequest("GET", "/actors?name=j.%20doe", nil)
+			actors := GetActors{}
+			json.Unmarshal(response.Body.Bytes(), &actors)
+
+			Convey("Then the actor should be found", func() {
+				So(len(actors.Actors), ShouldEqual, 1)
+				So(actors.Actors[0].ID, ShouldEqual, actor.ID)
+			})
+		})
+
+		Convey("When I call GET /actors/{id}", func() {
+			response := doRequest("GET", "/actors/"+id, nil)
+			a := Actor{}
+			json.Unmarshal(response.Body.Bytes(), &a)
+
+			Convey("Then the aliases should be listed", func() {
+				So(len(a.Aliases), ShouldEqual, 1)
+				So(a.Aliases[0].Name, ShouldEqual, "J. Doe")
+			})
+		})
+	})
+}
+
+func TestActorMerge(t *testing.T) {
+	Convey("Given an actor and a duplicate sharing a video", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		actor := Actor{Name: "Jane Doe"}
+		db.Create(&actor)
+		duplicate := Actor{Name: "Jane D.", Aliases: []ActorAlias{{Name: "JD"}}}
+		db.Create(&duplicate)
+		shared := Video{Title: "shared", Actors: []Actor{actor, duplicate}}
+		db.Create(&shared)
+		own := Video{Title: "own", Actors: []Actor{duplicate}}
+		db.Create(&own)
+		marker := Marker{VideoID: own.ID, ActorID: duplicate.ID}
+		db.Create(&marker)
+		route := "/actors/" + fmt.Sprint(actor.ID) + "/merge"
+		body := bytes.NewBufferString(`{"duplicate": "` + duplicate.Uuid + `"}`)
+
+		Convey("When a user without a role merges them", func() {
+			response := doRequest("POST", route, body)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When an editor merges the duplicate", func() {
+			response := doRequestAs(editor, "POST", route, body)
+			merged := Actor{}
+			json.Unmarshal(response.Body.Bytes(), &merged)
+
+			Convey("Then the canonical actor should have every video once", func() {
+				var n int
+				So(response.Code, ShouldEqual, 200)
+				db.Table("video_actors").Where("actor_id = ?", actor.ID).Count(&n)
+				So(n, ShouldEqual, 2)
+				db.Table("video_actors").Where("actor_id = ?", duplicate.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+			})
+
+			Convey("Then markers should point to the canonical actor", func() {
+				db.First(&marker, marker.ID)
+				So(marker.ActorID, ShouldEqual, actor.ID)
+			})
+
+			Convey("Then the duplicate names should become aliases", func() {
+				names := []string{}
+				for _, alias := range merged.Aliases {
+					names = append(names, alias.Name)
+				}
+				So(names, ShouldContain, "JD")
+				So(names, ShouldContain, "Jane D.")
+			})
+
+			Convey("Then the old ID should redirect to the canonical actor", func() {
+				response := doRequest("GET", "/actors/"+fmt.Sprint(duplicate.ID), nil)
+				So(response.Code, ShouldEqual, 301)
+				So(response.Header().Get("Location"), ShouldEqual, "/actors/"+fmt.Sprint(actor.ID))
+			})
+
+			Convey("Then the old UUID should redirect to the canonical UUID", func() {
+				response := doRequest("GET", "/actors/"+duplicate.Uuid, nil)
+				So(response.Code, ShouldEqual, 301)
+				So(response.Header().Get("Location"), ShouldEqual, "/actors/"+actor.Uuid)
+			})
+
+			Convey("When an editor restores the duplicate", func() {
+				doRequestAs(editor, "POST", "/actors/"+fmt.Sprint(duplicate.ID)+"/restore", nil)
+
+				Convey("Then it should no longer redirect", func() {
+					response := doRequest("GET", "/actors/"+fmt.Sprint(duplicate.ID), nil)
+					So(response.Code, ShouldEqual, 200)
+				})
+			})
+
+			Convey("When the duplicate is purged", func() {
+				purge(trashResources["actors"], []interface{}{duplicate.ID})
+
+				Convey("Then its redirect should be removed", func() {
+					var n int
+					db.Model(&ActorRedirect{}).Where("from_id = ?", duplicate.ID).Count(&n)
+					So(n, ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the canonical actor is purged", func() {
+				db.Delete(&actor)
+				purge(trashResources["actors"], []interface{}{actor.ID})
+
+				Convey("Then the redirects to it should be removed", func() {
+					var n int
+					db.Model(&ActorRedirect{}).Where("to_id = ?", actor.ID).Count(&n)
+					So(n, ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When an editor merges a duplicate with compliance records", func() {
+			db.Create(&ComplianceRecord{ActorID: duplicate.ID, LegalName: "Jane Doe"})
+			db.Create(&ComplianceDocument{ActorID: duplicate.ID, Type: "passport"})
+			doRequestAs(editor, "POST", route, body)
+
+			Convey("Then the move should be in the compliance audit log", func() {
+				var audit ComplianceAudit
+				So(db.Where("item_type = ? AND item_id = ? AND action = ?", "actors", actor.ID, "merge").
+					First(&audit).RecordNotFound(), ShouldBeFalse)
+				moved := ComplianceMerge{}
+				json.Unmarshal([]byte(audit.Details), &moved)
+				So(moved, ShouldResemble, ComplianceMerge{Duplicate: duplicate.ID, Documents: 1, Record: true})
+			})
+		})
+
+		Convey("When an editor merges an actor into itself", func() {
+			response := doRequestAs(editor, "POST", route, bytes.NewBufferString(`{"duplicate": "`+fmt.Sprint(actor.ID)+`"}`))
+
+			Convey("Then I should get a 422 response", func() {
+				So(response.Code, ShouldEqual, 422)
+			})
+		})
+	})
+}
@@ -94,15 +94,53 @@ var jwtMiddleware = jwtmiddleware.New(jwtmiddleware.Options{
 })
 
 func currentUser(r *http.Request) (u User) {
-	user := context.Get(r, "user").(*jwt.Token)
+	// Public routes are not behind the JWT middleware
+	user, ok := context.Get(r, "user").(*jwt.Token)
+	if !ok {
+		return u
+	}
 	claims, ok := user.Claims.(jwt.MapClaims)
 
 	if ok {
-		u.ID = claims["id"].(uint)
-		u.Name = claims["name"].(string)
-		u.UserName = claims["username"].(string)
-		u.Role = claims["role"].(string)
+		// Numeric claims are decoded from JSON as float64
+		if id, ok := claims["id"].(float64); ok {
+			u.ID = uint(id)
+		}
+		u.Name, _ = claims["name"].(string)
+		u.UserName, _ = claims["username"].(string)
+		u.Role, _ = claims["role"].(string)
 	}
 
 	return u
 }
+
+// hasRole tells whether the user has one of the given roles, admins have
+// every role
+func hasRole(u User, roles ...string) bool {
+	if u.Role == RoleAdmin {
+		return true
+	}
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// isEditor tells whether the user can see and curate the whole catalog
+func isEditor(u User) bool {
+	return hasRole(u, RoleEditor)
+}
+
+// requireRole only lets users with one of the given roles through to the
+// handler
+func requireRole(h http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(currentUser(r), roles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
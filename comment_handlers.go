@@ -0,0 +1,232 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// CommentRateWindow is the period over which comments of a user are counted
+// for rate limiting
+const CommentRateWindow = 10 * time.Minute
+
+// CommentRateLimit is the number of comments a user can post within
+// CommentRateWindow
+var CommentRateLimit = getEnvInt("COMMENT_RATE_LIMIT", 10)
+
+// commentSorts maps the sort query parameter to the order of top level
+// comments
+var commentSorts = map[string]string{
+	"newest": "id desc",
+	"oldest": "id",
+}
+
+// Comment is a message posted on a video, either top level or in reply to
+// another comment of the same video. RootID is the top level comment of the
+// thread, 0 for top level comments themselves.
+type Comment struct {
+	gorm.Model
+	Uuid      string     `json:"uuid"`
+	VideoID   uint       `json:"video_id" gorm:"index"`
+	ParentID  uint       `json:"parent_id"`
+	RootID    uint       `json:"root_id" gorm:"index"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	Body      string     `json:"body" gorm:"type:text"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Removed   bool       `json:"removed" gorm:"not null;default:false"`
+	RemovedBy uint       `json:"removed_by,omitempty"`
+	Replies   []*Comment `json:"replies" gorm:"-"`
+}
+
+type GetComments struct {
+	Nav      Navigation `json:"nav"`
+	Comments []*Comment `json:"comments"`
+}
+
+var CommentsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "newest"
+	}
+	order, ok := commentSorts[sort]
+	if !ok {
+		http.Error(w, "Unknown sort "+sort, http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	page := getPage(r)
+	threads := []*Comment{}
+	db.Where("video_id = ? AND root_id = 0", video.ID).Order(order).
+		Offset(page * limit).Limit(limit).Find(&threads)
+	nav := getNavigation(len(threads), page, limit)
+
+	// Replies are always listed oldest first under their parent
+	comments := map[uint]*Comment{}
+	roots := []uint{}
+	for _, c := range threads {
+		c.Replies = []*Comment{}
+		comments[c.ID] = c
+		roots = append(roots, c.ID)
+	}
+	replies := []*Comment{}
+	if len(roots) > 0 {
+		db.Where("root_id IN (?)", roots).Order("id").Find(&replies)
+	}
+	for _, c := range replies {
+		c.Replies = []*Comment{}
+		comments[c.ID] = c
+	}
+	for _, c := range replies {
+		if parent, ok := comments[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, c)
+		}
+	}
+
+	writeJSON(w, GetComments{Nav: nav, Comments: threads})
+})
+
+var CommentsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Comments are rate limited and edited by their author
+	user := currentUser(r)
+	if user.ID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t Comment
+	mapComment(r, &t)
+	if t.Body == "" {
+		http.Error(w, "Comment body is empty", http.StatusBadRequest)
+		return
+	}
+	if t.ParentID != 0 {
+		var parent Comment
+		if db.Where("video_id = ?", video.ID).First(&parent, t.ParentID).RecordNotFound() {
+			http.Error(w, "Parent comment not found on this video", http.StatusBadRequest)
+			return
+		}
+		t.RootID = parent.RootID
+		if t.RootID == 0 {
+			t.RootID = parent.ID
+		}
+	}
+
+	if commentsPostedSince(user, time.Now().Add(-CommentRateWindow)) >= CommentRateLimit {
+		w.Header().Set("Retry-After", fmt.Sprint(int(CommentRateWindow.Seconds())))
+		http.Error(w, "Too many comments, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	t.VideoID = video.ID
+	t.UserID = user.ID
+	db.Create(&t)
+	t.Replies = []*Comment{}
+
+	writeJSONStatus(w, http.StatusCreated, t)
+})
+
+var CommentPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var comment Comment
+	if getComment(r, &comment) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := currentUser(r)
+	if user.ID == 0 || comment.UserID != user.ID || comment.Removed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var t Comment
+	mapComment(r, &t)
+	if t.Body == "" {
+		http.Error(w, "Comment body is empty", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	comment.Body = t.Body
+	comment.EditedAt = &now
+	db.Save(&comment)
+	comment.Replies = []*Comment{}
+
+	writeJSON(w, comment)
+})
+
+// CommentDeleteHandler lets authors delete their comments and moderators
+// remove any comment. The comment is blanked rather than deleted so the
+// replies keep their place in the thread.
+var CommentDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var comment Comment
+	if getComment(r, &comment) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := currentUser(r)
+	author := user.ID != 0 && comment.UserID == user.ID
+	if !author && !hasRole(user, RoleModerator) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	db.Model(&comment).UpdateColumns(map[string]interface{}{
+		"body":       "",
+		"removed":    true,
+		"removed_by": user.ID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// commentsPostedSince counts the comments a user posted since the given time,
+// deleted ones included
+func commentsPostedSince(user User, since time.Time) int {
+	var n int
+	db.Unscoped().Model(&Comment{}).Where("user_id = ? AND created_at > ?", user.ID, since).Count(&n)
+	return n
+}
+
+func getComment(r *http.Request, comment *Comment) error {
+	return findByID(db, comment, mux.Vars(r)["id"])
+}
+
+func mapComment(r *http.Request, t *Comment) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Body = strings.TrimSpace(t.Body)
+	// Threads, authors and removals are maintained by the server
+	t.Uuid = ""
+	t.VideoID = 0
+	t.RootID = 0
+	t.UserID = 0
+	t.EditedAt = nil
+	t.Removed = false
+	t.RemovedBy = 0
+	t.Replies = nil
+}
@@ -0,0 +1,145 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComments(t *testing.T) {
+	Convey("Given a video with a comment and a reply", t, func() {
+		setupTestSuite()
+		alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+		bob := User{Model: gorm.Model{ID: 2}, Name: "bob"}
+		moderator := User{Model: gorm.Model{ID: 3}, Name: "moderator", Role: RoleModerator}
+		v := Video{Title: "test"}
+		db.Create(&v)
+		route := "/videos/" + fmt.Sprint(v.ID) + "/comments"
+
+		response := doRequestAs(alice, "POST", route, bytes.NewBufferString(`{"body": "first"}`))
+		first := Comment{}
+		json.Unmarshal(response.Body.Bytes(), &first)
+		response = doRequestAs(bob, "POST", route, bytes.NewBufferString(fmt.Sprintf(`{"body": "reply", "parent_id": %d}`, first.ID)))
+		reply := Comment{}
+		json.Unmarshal(response.Body.Bytes(), &reply)
+		doRequestAs(bob, "POST", route, bytes.NewBufferString(fmt.Sprintf(`{"body": "nested", "parent_id": %d}`, reply.ID)))
+		doRequestAs(bob, "POST", route, bytes.NewBufferString(`{"body": "second"}`))
+
+		Convey("When I call GET /videos/{id}/comments", func() {
+			response := doRequest("GET", route, nil)
+			comments := GetComments{}
+			json.Unmarshal(response.Body.Bytes(), &comments)
+
+			Convey("Then top level comments should be listed newest first", func() {
+				So(len(comments.Comments), ShouldEqual, 2)
+				So(comments.Comments[0].Body, ShouldEqual, "second")
+				So(comments.Comments[1].Body, ShouldEqual, "first")
+			})
+
+			Convey("Then replies should be nested under their parent", func() {
+				So(len(comments.Comments[1].Replies), ShouldEqual, 1)
+				So(comments.Comments[1].Replies[0].Body, ShouldEqual, "reply")
+				So(comments.Comments[1].Replies[0].Replies[0].Body, ShouldEqual, "nested")
+				So(comments.Comments[1].Replies[0].Replies[0].RootID, ShouldEqual, first.ID)
+			})
+		})
+
+		Convey("When I sort the comments oldest first", func() {
+			response := doRequest("GET", route+"?sort=oldest", nil)
+			comments := GetComments{}
+			json.Unmarshal(response.Body.Bytes(), &comments)
+
+			Convey("Then the first comment should come first", func() {
+				So(comments.Comments[0].Body, ShouldEqual, "first")
+			})
+		})
+
+		Convey("When I use an unknown sort", func() {
+			response := doRequest("GET", route+"?sort=best", nil)
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When the author edits the comment", func() {
+			response := doRequestAs(alice, "PATCH", "/comments/"+fmt.Sprint(first.ID), bytes.NewBufferString(`{"body": "edited"}`))
+
+			Convey("Then the comment should be updated", func() {
+				comment := Comment{}
+				db.First(&comment, first.ID)
+				So(response.Code, ShouldEqual, 200)
+				So(comment.Body, ShouldEqual, "edited")
+				So(comment.EditedAt, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When another user edits the comment", func() {
+			response := doRequestAs(bob, "PATCH", "/comments/"+fmt.Sprint(first.ID), bytes.NewBufferString(`{"body": "edited"}`))
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When another user deletes the comment", func() {
+			response := doRequestAs(bob, "DELETE", "/comments/"+fmt.Sprint(first.ID), nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When a moderator removes the comment", func() {
+			response := doRequestAs(moderator, "DELETE", "/comments/"+fmt.Sprint(first.ID), nil)
+
+			Convey("Then the comment should be blanked but keep its replies", func() {
+				comments := GetComments{}
+				json.Unmarshal(doRequest("GET", route+"?sort=oldest", nil).Body.Bytes(), &comments)
+				So(response.Code, ShouldEqual, 200)
+				So(comments.Comments[0].Removed, ShouldBeTrue)
+				So(comments.Comments[0].Body, ShouldEqual, "")
+				So(comments.Comments[0].RemovedBy, ShouldEqual, 3)
+				So(len(comments.Comments[0].Replies), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I reply to a comment of another video", func() {
+			other := Video{Title: "other"}
+			db.Create(&other)
+			response := doRequestAs(alice, "POST", "/videos/"+fmt.Sprint(other.ID)+"/comments",
+				bytes.NewBufferString(fmt.Sprintf(`{"body": "reply", "parent_id": %d}`, first.ID)))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When a token without a user comments", func() {
+			response := doRequest("POST", route, bytes.NewBufferString(`{"body": "anonymous"}`))
+
+			Convey("Then I should get a 401 response", func() {
+				So(response.Code, ShouldEqual, 401)
+			})
+		})
+
+		Convey("When a user goes over the rate limit", func() {
+			defer func(limit int) { CommentRateLimit = limit }(CommentRateLimit)
+			CommentRateLimit = 3
+			response := doRequestAs(bob, "POST", route, bytes.NewBufferString(`{"body": "spam"}`))
+
+			Convey("Then I should get a 429 response", func() {
+				So(response.Code, ShouldEqual, 429)
+				So(response.Header().Get("Retry-After"), ShouldNotBeEmpty)
+			})
+		})
+	})
+}
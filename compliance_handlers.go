@@ -0,0 +1,429 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// MinimumAge is the age every performer must have reached on the production
+// date of a video
+const MinimumAge = 18
+
+const (
+	// ComplianceNoProductionDate is the issue of a video whose production
+	// date is not recorded
+	ComplianceNoProductionDate = "no_production_date"
+	// ComplianceUnverified is the issue of an actor whose date of birth has
+	// not been verified
+	ComplianceUnverified = "unverified"
+	// ComplianceUnderage is the issue of an actor who was younger than
+	// MinimumAge on the production date
+	ComplianceUnderage = "underage"
+)
+
+// ComplianceRecord is the verified identity of an actor. It is kept apart
+// from Actor so that it never shows in the public API.
+type ComplianceRecord struct {
+	gorm.Model
+	Uuid        string     `json:"uuid"`
+	ActorID     uint       `json:"actor_id" gorm:"unique_index"`
+	LegalName   string     `json:"legal_name"`
+	DateOfBirth *time.Time `json:"date_of_birth"`
+	VerifiedBy  uint       `json:"verified_by"`
+	VerifiedAt  *time.Time `json:"verified_at"`
+	Notes       string     `json:"notes"`
+}
+
+// ComplianceDocument describes an identity document checked to verify the
+// age of an actor. Only its metadata is stored, Reference telling where the
+// copy is kept.
+type ComplianceDocument struct {
+	gorm.Model
+	Uuid       string     `json:"uuid"`
+	ActorID    uint       `json:"actor_id" gorm:"index"`
+	Type       string     `json:"type"`
+	Country    string     `json:"country"`
+	Number     string     `json:"number"`
+	IssuedAt   *time.Time `json:"issued_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Reference  string     `json:"reference"`
+	VerifiedBy uint       `json:"verified_by"`
+}
+
+// ProductionRecord is the production date of a video and the custodian of
+// its records
+type ProductionRecord struct {
+	gorm.Model
+	Uuid             string     `json:"uuid"`
+	VideoID          uint       `json:"video_id" gorm:"unique_index"`
+	ProducedAt       *time.Time `json:"produced_at"`
+	Producer         string     `json:"producer"`
+	CustodianName    string     `json:"custodian_name"`
+	CustodianAddress string     `json:"custodian_address"`
+	Notes            string     `json:"notes"`
+}
+
+// ComplianceAudit records every access to the compliance records
+type ComplianceAudit struct {
+	gorm.Model
+	Uuid     string   `json:"uuid"`
+	UserID   uint     `json:"user_id" gorm:"index"`
+	Action   string   `json:"action"`
+	ItemType string   `json:"item_type" gorm:"index:idx_compliance_audit_item"`
+	ItemID   uint     `json:"item_id" gorm:"index:idx_compliance_audit_item"`
+	Address  string   `json:"address"`
+	Details  JSONText `json:"details" gorm:"type:text"`
+}
+
+// ComplianceRecordUpdate is the body of an actor compliance update, the
+// fields left out keeping their value
+type ComplianceRecordUpdate struct {
+	LegalName   *string    `json:"legal_name"`
+	DateOfBirth *time.Time `json:"date_of_birth"`
+	Notes       *string    `json:"notes"`
+}
+
+// ProductionRecordUpdate is the body of a video compliance update, the
+// fields left out keeping their value
+type ProductionRecordUpdate struct {
+	ProducedAt       *time.Time `json:"produced_at"`
+	Producer         *string    `json:"producer"`
+	CustodianName    *string    `json:"custodian_name"`
+	CustodianAddress *string    `json:"custodian_address"`
+	Notes            *string    `json:"notes"`
+}
+
+// ComplianceChange is the audited detail of an update, Before being null
+// when the record did not exist
+type ComplianceChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ComplianceMerge is the audited detail of an actor merge which moved the
+// compliance records of a duplicate onto the actor
+type ComplianceMerge struct {
+	Duplicate uint  `json:"duplicate"`
+	Documents int64 `json:"documents"`
+	Record    bool  `json:"record"`
+}
+
+type ComplianceIssue struct {
+	ActorID uint   `json:"actor_id,omitempty"`
+	Problem string `json:"problem"`
+}
+
+type ActorCompliance struct {
+	Actor     Actor                `json:"actor"`
+	Record    *ComplianceRecord    `json:"record"`
+	Documents []ComplianceDocument `json:"documents"`
+}
+
+type VideoCompliance struct {
+	Video      Video             `json:"video"`
+	Production *ProductionRecord `json:"production"`
+	Compliant  bool              `json:"compliant"`
+	Issues     []ComplianceIssue `json:"issues"`
+}
+
+type GetVideoCompliances struct {
+	Nav    Navigation        `json:"nav"`
+	Videos []VideoCompliance `json:"videos"`
+}
+
+type GetComplianceAudits struct {
+	Nav    Navigation        `json:"nav"`
+	Audits []ComplianceAudit `json:"audits"`
+}
+
+var ActorComplianceGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	auditCompliance(r, "view", "actors", actor.ID, nil)
+
+	writeJSON(w, actorCompliance(actor))
+})
+
+var ActorCompliancePatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t ComplianceRecordUpdate
+	mapComplianceRecordUpdate(r, &t)
+	if t.DateOfBirth != nil && t.DateOfBirth.After(time.Now()) {
+		http.Error(w, "Date of birth is in the future", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var record ComplianceRecord
+	db.Where(ComplianceRecord{ActorID: actor.ID}).FirstOrInit(&record)
+	change := ComplianceChange{}
+	if record.ID != 0 {
+		change.Before = record
+	}
+	if t.LegalName != nil {
+		record.LegalName = *t.LegalName
+	}
+	if t.Notes != nil {
+		record.Notes = *t.Notes
+	}
+	if t.DateOfBirth != nil {
+		now := time.Now()
+		record.DateOfBirth = t.DateOfBirth
+		record.VerifiedBy = currentUser(r).ID
+		record.VerifiedAt = &now
+	}
+	db.Save(&record)
+	change.After = record
+	auditCompliance(r, "update", "actors", actor.ID, change)
+
+	writeJSON(w, actorCompliance(actor))
+})
+
+var ComplianceDocumentsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t ComplianceDocument
+	mapComplianceDocument(r, &t)
+	t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+	if t.Type == "" {
+		http.Error(w, "Document type is empty", http.StatusBadRequest)
+		return
+	}
+
+	t.ActorID = actor.ID
+	t.VerifiedBy = currentUser(r).ID
+	db.Create(&t)
+	auditCompliance(r, "add_document", "actors", actor.ID, t)
+
+	writeJSONStatus(w, http.StatusCreated, t)
+})
+
+var VideoComplianceGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	auditCompliance(r, "view", "videos", video.ID, nil)
+
+	writeJSON(w, videoCompliances([]Video{video})[0])
+})
+
+var VideoCompliancePatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t ProductionRecordUpdate
+	mapProductionRecordUpdate(r, &t)
+	if t.ProducedAt != nil && t.ProducedAt.After(time.Now()) {
+		http.Error(w, "Production date is in the future", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var record ProductionRecord
+	db.Where(ProductionRecord{VideoID: video.ID}).FirstOrInit(&record)
+	change := ComplianceChange{}
+	if record.ID != 0 {
+		change.Before = record
+	}
+	if t.ProducedAt != nil {
+		record.ProducedAt = t.ProducedAt
+	}
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	set(&record.Producer, t.Producer)
+	set(&record.CustodianName, t.CustodianName)
+	set(&record.CustodianAddress, t.CustodianAddress)
+	set(&record.Notes, t.Notes)
+	db.Save(&record)
+	change.After = record
+	auditCompliance(r, "update", "videos", video.ID, change)
+
+	writeJSON(w, videoCompliances([]Video{video})[0])
+})
+
+// ComplianceReportHandler lists the videos which cannot be proven to only
+// feature adults
+var ComplianceReportHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	page := getPage(r)
+	videos := []Video{}
+	db.Order("id").Find(&videos)
+
+	failing := []VideoCompliance{}
+	for _, compliance := range videoCompliances(videos) {
+		if !compliance.Compliant {
+			failing = append(failing, compliance)
+		}
+	}
+	start, end := page*limit, (page+1)*limit
+	if start > len(failing) {
+		start = len(failing)
+	}
+	if end > len(failing) {
+		end = len(failing)
+	}
+	nav := getNavigation(end-start, page, limit)
+	auditCompliance(r, "report", "videos", 0, nil)
+
+	writeJSON(w, GetVideoCompliances{Nav: nav, Videos: failing[start:end]})
+})
+
+var ComplianceAuditGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	page := getPage(r)
+	audits := []ComplianceAudit{}
+	query := db
+	if itemType := r.URL.Query().Get("item_type"); itemType != "" {
+		query = query.Where("item_type = ?", itemType)
+	}
+	if itemID := r.URL.Query().Get("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	query.Order("id desc").Offset(page * limit).Limit(limit).Find(&audits)
+	nav := getNavigation(len(audits), page, limit)
+
+	writeJSON(w, GetComplianceAudits{Nav: nav, Audits: audits})
+})
+
+// videoCompliances checks that every actor of the videos had a verified age
+// of at least MinimumAge on the production date
+func videoCompliances(videos []Video) []VideoCompliance {
+	compliances := make([]VideoCompliance, len(videos))
+	if len(videos) == 0 {
+		return compliances
+	}
+	videoIDs := make([]uint, len(videos))
+	for i, video := range videos {
+		videoIDs[i] = video.ID
+	}
+
+	links := []struct {
+		VideoID uint
+		ActorID uint
+	}{}
+	db.Table("video_actors").Select("video_id, actor_id").Where("video_id IN (?)", videoIDs).
+		Order("actor_id").Scan(&links)
+	actors := map[uint][]uint{}
+	actorIDs := []uint{}
+	for _, link := range links {
+		actors[link.VideoID] = append(actors[link.VideoID], link.ActorID)
+		actorIDs = append(actorIDs, link.ActorID)
+	}
+
+	productions := []ProductionRecord{}
+	db.Where("video_id IN (?)", videoIDs).Find(&productions)
+	produced := map[uint]*ProductionRecord{}
+	for i := range productions {
+		produced[productions[i].VideoID] = &productions[i]
+	}
+	records := []ComplianceRecord{}
+	if len(actorIDs) > 0 {
+		db.Where("actor_id IN (?)", actorIDs).Find(&records)
+	}
+	births := map[uint]time.Time{}
+	for _, record := range records {
+		if record.DateOfBirth != nil {
+			births[record.ActorID] = *record.DateOfBirth
+		}
+	}
+
+	for i, video := range videos {
+		compliance := VideoCompliance{Video: video, Production: produced[video.ID], Issues: []ComplianceIssue{}}
+		if compliance.Production == nil || compliance.Production.ProducedAt == nil {
+			compliance.Issues = append(compliance.Issues, ComplianceIssue{Problem: ComplianceNoProductionDate})
+		}
+		for _, actorID := range actors[video.ID] {
+			birth, ok := births[actorID]
+			switch {
+			case !ok:
+				compliance.Issues = append(compliance.Issues, ComplianceIssue{ActorID: actorID, Problem: ComplianceUnverified})
+			case compliance.Production != nil && compliance.Production.ProducedAt != nil &&
+				birth.AddDate(MinimumAge, 0, 0).After(*compliance.Production.ProducedAt):
+				compliance.Issues = append(compliance.Issues, ComplianceIssue{ActorID: actorID, Problem: ComplianceUnderage})
+			}
+		}
+		compliance.Compliant = len(compliance.Issues) == 0
+		compliances[i] = compliance
+	}
+	return compliances
+}
+
+func actorCompliance(actor Actor) ActorCompliance {
+	compliance := ActorCompliance{Actor: actor, Documents: []ComplianceDocument{}}
+	var record ComplianceRecord
+	if !db.Where("actor_id = ?", actor.ID).First(&record).RecordNotFound() {
+		compliance.Record = &record
+	}
+	db.Where("actor_id = ?", actor.ID).Order("id").Find(&compliance.Documents)
+	return compliance
+}
+
+// auditCompliance records an access to the compliance records of an item
+func auditCompliance(r *http.Request, action string, itemType string, itemID uint, details interface{}) {
+	audit := ComplianceAudit{
+		UserID:   currentUser(r).ID,
+		Action:   action,
+		ItemType: itemType,
+		ItemID:   itemID,
+		Address:  r.RemoteAddr,
+	}
+	if details != nil {
+		data, _ := json.Marshal(details)
+		audit.Details = JSONText(data)
+	}
+	if err := db.Create(&audit).Error; err != nil {
+		log.Printf("Could not record compliance audit: %s", err)
+	}
+}
+
+func mapComplianceRecordUpdate(r *http.Request, t *ComplianceRecordUpdate) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
+
+func mapComplianceDocument(r *http.Request, t *ComplianceDocument) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Model = gorm.Model{}
+	t.Uuid = ""
+}
+
+func mapProductionRecordUpdate(r *http.Request, t *ProductionRecordUpdate) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
@@ -0,0 +1,149 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompliance(t *testing.T) {
+	Convey("Given a video with two actors", t, func() {
+		setupTestSuite()
+		admin := User{Name: "admin", Role: RoleAdmin}
+		adult := Actor{Name: "Adult"}
+		young := Actor{Name: "Young"}
+		v := Video{Title: "test", Actors: []Actor{adult, young}}
+		db.Create(&v)
+		db.Model(&v).Related(&v.Actors, "Actors")
+		adult, young = v.Actors[0], v.Actors[1]
+		id := fmt.Sprint(v.ID)
+
+		Convey("When an editor asks for the compliance report", func() {
+			response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "GET", "/compliance/report", nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When nothing is recorded", func() {
+			response := doRequestAs(admin, "GET", "/compliance/videos/"+id, nil)
+			compliance := VideoCompliance{}
+			json.Unmarshal(response.Body.Bytes(), &compliance)
+
+			Convey("Then the video should miss its production date and verified actors", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(compliance.Compliant, ShouldBeFalse)
+				So(len(compliance.Issues), ShouldEqual, 3)
+				So(compliance.Issues[0].Problem, ShouldEqual, ComplianceNoProductionDate)
+				So(compliance.Issues[1].Problem, ShouldEqual, ComplianceUnverified)
+			})
+		})
+
+		Convey("When the production date and dates of birth are recorded", func() {
+			doRequestAs(admin, "PATCH", fmt.Sprintf("/compliance/actors/%d", adult.ID),
+				bytes.NewBufferString(`{"legal_name": "Jane Doe", "date_of_birth": "1990-01-01T00:00:00Z"}`))
+			doRequestAs(admin, "POST", fmt.Sprintf("/compliance/actors/%d/documents", adult.ID),
+				bytes.NewBufferString(`{"type": "Passport", "country": "FR", "number": "12AB34567"}`))
+			doRequestAs(admin, "PATCH", fmt.Sprintf("/compliance/actors/%d", young.ID),
+				bytes.NewBufferString(`{"legal_name": "John Roe", "date_of_birth": "1998-06-16T00:00:00Z"}`))
+			response := doRequestAs(admin, "PATCH", "/compliance/videos/"+id,
+				bytes.NewBufferString(`{"produced_at": "2016-06-15T00:00:00Z", "custodian_name": "Records Inc"}`))
+			compliance := VideoCompliance{}
+			json.Unmarshal(response.Body.Bytes(), &compliance)
+
+			Convey("Then the actor who was not 18 yet should be reported", func() {
+				So(compliance.Compliant, ShouldBeFalse)
+				So(len(compliance.Issues), ShouldEqual, 1)
+				So(compliance.Issues[0].ActorID, ShouldEqual, young.ID)
+				So(compliance.Issues[0].Problem, ShouldEqual, ComplianceUnderage)
+				So(compliance.Production.CustodianName, ShouldEqual, "Records Inc")
+			})
+
+			Convey("Then the video should be in the report", func() {
+				response := doRequestAs(admin, "GET", "/compliance/report", nil)
+				report := GetVideoCompliances{}
+				json.Unmarshal(response.Body.Bytes(), &report)
+				So(len(report.Videos), ShouldEqual, 1)
+				So(report.Videos[0].Video.ID, ShouldEqual, v.ID)
+			})
+
+			Convey("Then the actor records should include the documents", func() {
+				response := doRequestAs(admin, "GET", fmt.Sprintf("/compliance/actors/%d", adult.ID), nil)
+				compliance := ActorCompliance{}
+				json.Unmarshal(response.Body.Bytes(), &compliance)
+				So(compliance.Record.LegalName, ShouldEqual, "Jane Doe")
+				So(len(compliance.Documents), ShouldEqual, 1)
+				So(compliance.Documents[0].Type, ShouldEqual, "passport")
+			})
+
+			Convey("When only the notes of an actor are updated", func() {
+				doRequestAs(admin, "PATCH", fmt.Sprintf("/compliance/actors/%d", adult.ID),
+					bytes.NewBufferString(`{"notes": "Checked twice"}`))
+
+				Convey("Then the other fields should be kept", func() {
+					var record ComplianceRecord
+					db.Where("actor_id = ?", adult.ID).First(&record)
+					So(record.LegalName, ShouldEqual, "Jane Doe")
+					So(record.DateOfBirth, ShouldNotBeNil)
+					So(record.Notes, ShouldEqual, "Checked twice")
+				})
+
+				Convey("Then the audit log should keep the values before and after", func() {
+					var audit ComplianceAudit
+					db.Where("item_type = ? AND item_id = ?", "actors", adult.ID).Order("id desc").First(&audit)
+					change := struct {
+						Before ComplianceRecord `json:"before"`
+						After  ComplianceRecord `json:"after"`
+					}{}
+					json.Unmarshal([]byte(audit.Details), &change)
+					So(change.Before.Notes, ShouldEqual, "")
+					So(change.Before.LegalName, ShouldEqual, "Jane Doe")
+					So(change.After.Notes, ShouldEqual, "Checked twice")
+				})
+			})
+
+			Convey("When the production date is corrected", func() {
+				doRequestAs(admin, "PATCH", "/compliance/videos/"+id,
+					bytes.NewBufferString(`{"produced_at": "2016-06-16T00:00:00Z"}`))
+
+				Convey("Then the custodian should be kept", func() {
+					var record ProductionRecord
+					db.Where("video_id = ?", v.ID).First(&record)
+					So(record.CustodianName, ShouldEqual, "Records Inc")
+				})
+
+				Convey("Then the video should no longer be in the report", func() {
+					response := doRequestAs(admin, "GET", "/compliance/report", nil)
+					report := GetVideoCompliances{}
+					json.Unmarshal(response.Body.Bytes(), &report)
+					So(len(report.Videos), ShouldEqual, 0)
+				})
+
+				Convey("Then every access should be in the audit log", func() {
+					response := doRequestAs(admin, "GET", "/compliance/audit?item_type=videos&item_id="+id, nil)
+					audits := GetComplianceAudits{}
+					json.Unmarshal(response.Body.Bytes(), &audits)
+					So(len(audits.Audits), ShouldEqual, 2)
+					So(audits.Audits[0].Action, ShouldEqual, "update")
+				})
+			})
+		})
+
+		Convey("When a production date in the future is recorded", func() {
+			response := doRequestAs(admin, "PATCH", "/compliance/videos/"+id,
+				bytes.NewBufferString(`{"produced_at": "2999-01-01T00:00:00Z"}`))
+
+			Convey("Then I should get a 422 response", func() {
+				So(response.Code, ShouldEqual, 422)
+			})
+		})
+	})
+}
@@ -0,0 +1,270 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// CostarGraphTTL is how long the co-star graph is reused before being
+	// rebuilt from the catalog
+	CostarGraphTTL = 10 * time.Minute
+	// DefaultPathDepth is the default maximum number of videos in a path
+	DefaultPathDepth = 6
+	// MaxPathDepth caps the depth a client can ask for
+	MaxPathDepth = 12
+)
+
+// costarEdge links two actors who played together in Count public videos,
+// VideoID being one of them
+type costarEdge struct {
+	Count   int
+	VideoID uint
+}
+
+// costarGraph maps every actor to their co-stars. It is built from public
+// videos only, so every user sees the same graph.
+type costarGraph struct {
+	edges map[uint]map[uint]*costarEdge
+	built time.Time
+}
+
+var (
+	costarGraphMu    sync.Mutex
+	costarGraphCache *costarGraph
+)
+
+type Costar struct {
+	Actor  Actor `json:"actor"`
+	Videos int   `json:"videos"`
+}
+
+type GetCostars struct {
+	Nav     Navigation `json:"nav"`
+	Costars []Costar   `json:"costars"`
+}
+
+// ActorPath is a chain of actors where Videos[i] links Actors[i] to
+// Actors[i+1]
+type ActorPath struct {
+	Degrees int     `json:"degrees"`
+	Actors  []Actor `json:"actors"`
+	Videos  []Video `json:"videos"`
+}
+
+var CostarsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	graph := getCostarGraph()
+	ids := []uint{}
+	for id := range graph.edges[actor.ID] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := graph.edges[actor.ID][ids[i]], graph.edges[actor.ID][ids[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return ids[i] < ids[j]
+	})
+
+	limit := 100
+	page := getPage(r)
+	if page*limit < len(ids) {
+		ids = ids[page*limit:]
+	} else {
+		ids = nil
+	}
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	nav := getNavigation(len(ids), page, limit)
+
+	actors := map[uint]Actor{}
+	if len(ids) > 0 {
+		found := []Actor{}
+		db.Where("id IN (?)", ids).Find(&found)
+		for _, a := range found {
+			actors[a.ID] = a
+		}
+	}
+	costars := []Costar{}
+	for _, id := range ids {
+		if a, ok := actors[id]; ok {
+			costars = append(costars, Costar{Actor: a, Videos: graph.edges[actor.ID][id].Count})
+		}
+	}
+
+	writeJSON(w, GetCostars{Nav: nav, Costars: costars})
+})
+
+var ActorPathGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var from, to Actor
+	if getActor(r, &from) != nil || findByID(db, &to, mux.Vars(r)["other"]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	depth := DefaultPathDepth
+	if value := r.URL.Query().Get("max_depth"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxPathDepth {
+			http.Error(w, "max_depth must be between 1 and "+strconv.Itoa(MaxPathDepth), http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+
+	graph := getCostarGraph()
+	ids := graph.path(from.ID, to.ID, depth)
+	if ids == nil {
+		http.Error(w, "No path between these actors within "+strconv.Itoa(depth)+" videos", http.StatusNotFound)
+		return
+	}
+
+	path := ActorPath{Degrees: len(ids) - 1, Actors: []Actor{}, Videos: []Video{}}
+	for i, id := range ids {
+		var actor Actor
+		db.First(&actor, id)
+		path.Actors = append(path.Actors, actor)
+		if i > 0 {
+			var video Video
+			db.First(&video, graph.edges[ids[i-1]][id].VideoID)
+			path.Videos = append(path.Videos, video)
+		}
+	}
+
+	writeJSON(w, path)
+})
+
+// getCostarGraph returns the cached co-star graph, rebuilding it once it is
+// older than CostarGraphTTL
+func getCostarGraph() *costarGraph {
+	costarGraphMu.Lock()
+	defer costarGraphMu.Unlock()
+	if costarGraphCache == nil || time.Since(costarGraphCache.built) > CostarGraphTTL {
+		costarGraphCache = buildCostarGraph()
+	}
+	return costarGraphCache
+}
+
+// invalidateCostarGraph makes the next request rebuild the co-star graph
+func invalidateCostarGraph() {
+	costarGraphMu.Lock()
+	defer costarGraphMu.Unlock()
+	costarGraphCache = nil
+}
+
+func buildCostarGraph() *costarGraph {
+	rows := []struct {
+		VideoID uint
+		ActorID uint
+	}{}
+	db.Table("video_actors").Select("video_actors.video_id, video_actors.actor_id").
+		Joins("JOIN videos ON videos.id = video_actors.video_id AND videos.deleted_at IS NULL").
+		Joins("JOIN actors ON actors.id = video_actors.actor_id AND actors.deleted_at IS NULL").
+		Where("videos.hidden = ? AND videos.status = ? AND (videos.uploaded IS NULL OR videos.uploaded <= ?)",
+			false, VideoPublished, time.Now()).
+		Order("video_actors.video_id").Scan(&rows)
+
+	cast := map[uint][]uint{}
+	for _, row := range rows {
+		cast[row.VideoID] = append(cast[row.VideoID], row.ActorID)
+	}
+
+	graph := &costarGraph{edges: map[uint]map[uint]*costarEdge{}, built: time.Now()}
+	for video, actors := range cast {
+		for _, a := range actors {
+			for _, b := range actors {
+				if a != b {
+					graph.link(a, b, video)
+				}
+			}
+		}
+	}
+	return graph
+}
+
+func (g *costarGraph) link(a, b, video uint) {
+	if g.edges[a] == nil {
+		g.edges[a] = map[uint]*costarEdge{}
+	}
+	edge := g.edges[a][b]
+	if edge == nil {
+		edge = &costarEdge{VideoID: video}
+		g.edges[a][b] = edge
+	}
+	edge.Count++
+	if video < edge.VideoID {
+		edge.VideoID = video
+	}
+}
+
+// path returns the shortest chain of actors from one actor to another with
+// at most maxDepth links, or nil. It runs a breadth first search from both
+// ends, always growing the smaller frontier.
+func (g *costarGraph) path(from, to uint, maxDepth int) []uint {
+	if from == to {
+		return []uint{from}
+	}
+	parents := [2]map[uint]uint{{from: from}, {to: to}}
+	frontiers := [2][]uint{{from}, {to}}
+
+	for depth := 0; depth < maxDepth; depth++ {
+		side := 0
+		if len(frontiers[1]) < len(frontiers[0]) {
+			side = 1
+		}
+		if len(frontiers[side]) == 0 {
+			return nil
+		}
+
+		next := []uint{}
+		meeting, found := uint(0), false
+		for _, actor := range frontiers[side] {
+			for costar := range g.edges[actor] {
+				if _, seen := parents[side][costar]; seen {
+					continue
+				}
+				parents[side][costar] = actor
+				next = append(next, costar)
+				if _, ok := parents[1-side][costar]; ok && (!found || costar < meeting) {
+					meeting, found = costar, true
+				}
+			}
+		}
+		if found {
+			return joinPath(parents, from, to, meeting)
+		}
+		frontiers[side] = next
+	}
+	return nil
+}
+
+// joinPath builds the path going through the actor where both searches met
+func joinPath(parents [2]map[uint]uint, from, to, meeting uint) []uint {
+	path := []uint{meeting}
+	for actor := meeting; actor != from; {
+		actor = parents[0][actor]
+		path = append([]uint{actor}, path...)
+	}
+	for actor := meeting; actor != to; {
+		actor = parents[1][actor]
+		path = append(path, actor)
+	}
+	return path
+}
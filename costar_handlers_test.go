@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCostars(t *testing.T) {
+	Convey("Given actors linked by a chain of videos", t, func() {
+		setupTestSuite()
+		invalidateCostarGraph()
+		actors := make([]Actor, 6)
+		for i := range actors {
+			actors[i] = Actor{Name: fmt.Sprint("Actor", i)}
+			db.Create(&actors[i])
+		}
+		cast := func(title string, members ...int) Video {
+			v := Video{Title: title}
+			for _, i := range members {
+				v.Actors = append(v.Actors, actors[i])
+			}
+			db.Create(&v)
+			return v
+		}
+		// 0 - 1 - 2 - 3 with a shortcut 0 - 4 - 3, 5 on its own
+		cast("a", 0, 1)
+		cast("b", 0, 1)
+		cast("c", 1, 2)
+		cast("d", 2, 3)
+		cast("e", 0, 4)
+		shortcut := cast("f", 4, 3)
+		hidden := cast("g", 0, 3)
+		db.Model(&hidden).UpdateColumn("hidden", true)
+		id := func(i int) string { return fmt.Sprint(actors[i].ID) }
+
+		Convey("When I call GET /actors/{id}/costars", func() {
+			response := doRequest("GET", "/actors/"+id(0)+"/costars", nil)
+			costars := GetCostars{}
+			json.Unmarshal(response.Body.Bytes(), &costars)
+
+			Convey("Then co-stars should be sorted by collaborations", func() {
+				So(len(costars.Costars), ShouldEqual, 2)
+				So(costars.Costars[0].Actor.ID, ShouldEqual, actors[1].ID)
+				So(costars.Costars[0].Videos, ShouldEqual, 2)
+				So(costars.Costars[1].Actor.ID, ShouldEqual, actors[4].ID)
+			})
+		})
+
+		Convey("When I ask for the path between two actors", func() {
+			response := doRequest("GET", "/actors/"+id(0)+"/path/"+id(3), nil)
+			path := ActorPath{}
+			json.Unmarshal(response.Body.Bytes(), &path)
+
+			Convey("Then the shortest chain should be returned", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(path.Degrees, ShouldEqual, 2)
+				So(path.Actors[1].ID, ShouldEqual, actors[4].ID)
+				So(path.Videos[1].ID, ShouldEqual, shortcut.ID)
+			})
+		})
+
+		Convey("When the path is longer than the maximum depth", func() {
+			response := doRequest("GET", "/actors/"+id(1)+"/path/"+id(3)+"?max_depth=1", nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+
+		Convey("When there is no path", func() {
+			response := doRequest("GET", "/actors/"+id(0)+"/path/"+id(5), nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+
+		Convey("When I ask for the path from an actor to themselves", func() {
+			response := doRequest("GET", "/actors/"+id(2)+"/path/"+id(2), nil)
+			path := ActorPath{}
+			json.Unmarshal(response.Body.Bytes(), &path)
+
+			Convey("Then the path should have no degrees", func() {
+				So(path.Degrees, ShouldEqual, 0)
+				So(len(path.Actors), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,148 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	tagPattern  = regexp.MustCompile(`(?s)<\s*([a-zA-Z][a-zA-Z0-9:-]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	attrPattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	sizePattern = regexp.MustCompile(`^\d{1,4}%?$`)
+)
+
+// htmlTag is an opening tag found in a HTML document
+type htmlTag struct {
+	Name  string
+	Attrs map[string]string
+}
+
+// parseTags returns every opening tag of a HTML document with its attributes
+// unescaped. It is not a full HTML parser, it is only meant to pick known
+// elements out of third party markup.
+func parseTags(document string) []htmlTag {
+	tags := []htmlTag{}
+	for _, m := range tagPattern.FindAllStringSubmatch(document, -1) {
+		tag := htmlTag{Name: strings.ToLower(m[1]), Attrs: map[string]string{}}
+		for _, a := range attrPattern.FindAllStringSubmatch(m[2], -1) {
+			value := a[2] + a[3] + a[4]
+			tag.Attrs[strings.ToLower(a[1])] = html.UnescapeString(value)
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// embedAttrs are the iframe attributes kept by sanitizeEmbed, along with the
+// validation their values must pass
+var embedAttrs = []struct {
+	Name  string
+	Valid func(string) bool
+}{
+	{"width", sizePattern.MatchString},
+	{"height", sizePattern.MatchString},
+	{"frameborder", func(v string) bool { return v == "0" || v == "1" }},
+	{"scrolling", func(v string) bool { return v == "yes" || v == "no" || v == "auto" }},
+	{"allowfullscreen", func(v string) bool { return true }},
+}
+
+// sanitizeEmbed rebuilds embed markup keeping only iframes pointing to the
+// domain of the given tube. Everything else is dropped, and the iframes are
+// rendered from scratch so nothing but whitelisted attributes survives.
+func sanitizeEmbed(embed string, tube Tube) string {
+	domain := tubeDomain(tube)
+	if domain == "" {
+		return ""
+	}
+
+	iframes := []string{}
+	for _, tag := range parseTags(embed) {
+		if tag.Name != "iframe" {
+			continue
+		}
+		src, ok := embedSource(tag.Attrs["src"], domain)
+		if !ok {
+			continue
+		}
+		tag.Attrs["src"] = src
+		iframes = append(iframes, renderIframe(tag))
+	}
+	return strings.Join(iframes, "")
+}
+
+// renderIframe renders an iframe with its source and whitelisted attributes
+func renderIframe(tag htmlTag) string {
+	iframe := `<iframe src="` + html.EscapeString(tag.Attrs["src"]) + `"`
+	for _, attr := range embedAttrs {
+		value, ok := tag.Attrs[attr.Name]
+		if !ok || !attr.Valid(value) {
+			continue
+		}
+		if attr.Name == "allowfullscreen" {
+			iframe += " allowfullscreen"
+			continue
+		}
+		iframe += " " + attr.Name + `="` + value + `"`
+	}
+	return iframe + "></iframe>"
+}
+
+// embedSource validates the source of an embedded iframe against a domain
+// and returns it as an absolute URL
+func embedSource(src string, domain string) (string, bool) {
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// tubeDomain returns the domain embeds of a tube are allowed to come from
+func tubeDomain(tube Tube) string {
+	u, err := url.Parse(tube.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// sanitizeVideoEmbed sanitizes the embed of a video against its saved tube,
+// dropping it when the video has none. The tube given along with the video
+// comes from the client and cannot be trusted.
+func sanitizeVideoEmbed(video *Video) {
+	if video.Embed == "" {
+		return
+	}
+	var tube Tube
+	if video.TubeID == 0 || db.First(&tube, video.TubeID).RecordNotFound() {
+		video.Embed = ""
+		return
+	}
+	video.Embed = sanitizeEmbed(video.Embed, tube)
+}
+
+// embedSize returns the size of the first iframe of an embed
+func embedSize(embed string) (width int, height int) {
+	for _, tag := range parseTags(embed) {
+		if tag.Name == "iframe" {
+			width, _ = strconv.Atoi(tag.Attrs["width"])
+			height, _ = strconv.Atoi(tag.Attrs["height"])
+			return width, height
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSanitizeEmbed(t *testing.T) {
+	Convey("Given a tube hosted at www.tube.com", t, func() {
+		tube := Tube{Name: "tube", URL: "https://www.tube.com"}
+
+		Convey("When the embed is an iframe from the tube", func() {
+			embed := `<iframe src="https://embed.tube.com/v/1" width="640" height="360" frameborder="0" onload="alert(1)" allowfullscreen></iframe>`
+
+			Convey("Then only whitelisted attributes should be kept", func() {
+				So(sanitizeEmbed(embed, tube), ShouldEqual,
+					`<iframe src="https://embed.tube.com/v/1" width="640" height="360" frameborder="0" allowfullscreen></iframe>`)
+			})
+		})
+
+		Convey("When the embed points to another domain", func() {
+			embed := `<iframe src="https://evil.com/?tube.com"></iframe>`
+
+			Convey("Then the iframe should be dropped", func() {
+				So(sanitizeEmbed(embed, tube), ShouldEqual, "")
+			})
+		})
+
+		Convey("When the embed carries scripts and javascript sources", func() {
+			embed := `<script>alert(1)</script><iframe src="javascript:alert(1)"></iframe><iframe src='//tube.com/v/2' width="100%"></iframe>`
+
+			Convey("Then only the valid iframe should survive", func() {
+				So(sanitizeEmbed(embed, tube), ShouldEqual, `<iframe src="https://tube.com/v/2" width="100%"></iframe>`)
+			})
+		})
+	})
+
+	Convey("Given a video without tube", t, func() {
+		Convey("Then no embed should be allowed", func() {
+			So(sanitizeEmbed(`<iframe src="https://tube.com/v/1"></iframe>`, Tube{}), ShouldEqual, "")
+		})
+	})
+	Convey("Given a video posted along with its own tube", t, func() {
+		setupTestSuite()
+		body := `{"title": "evil", "embed": "<iframe src=\"https://evil.example/v/1\"></iframe>", "tube": {"name": "evil", "url": "https://evil.example"}}`
+		response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", "/videos", bytes.NewBufferString(body))
+		video := Video{}
+		json.Unmarshal(response.Body.Bytes(), &video)
+
+		Convey("Then the embed should be dropped and no tube created", func() {
+			So(video.Embed, ShouldEqual, "")
+			var n int
+			db.Model(&Tube{}).Count(&n)
+			So(n, ShouldEqual, 0)
+		})
+	})
+}
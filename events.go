@@ -0,0 +1,43 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// EventVideoPublished is emitted when a video goes live
+const EventVideoPublished = "video.published"
+
+// Event is a change other parts of the application can react to
+type Event struct {
+	Type string
+	Time time.Time
+	Data interface{}
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []func(Event)
+)
+
+// Subscribe registers a function called with every emitted event. Subscribers
+// are called in turn from the emitting goroutine and should return quickly.
+func Subscribe(subscriber func(Event)) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, subscriber)
+}
+
+// emit sends an event to every subscriber
+func emit(eventType string, data interface{}) {
+	event := Event{Type: eventType, Time: time.Now(), Data: data}
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for _, subscriber := range subscribers {
+		subscriber(event)
+	}
+}
@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// ActorTopTags is the number of tags listed in the statistics of an actor
+const ActorTopTags = 10
+
+// filmographySorts maps the sort query parameter to the order of the videos
+// of an actor
+var filmographySorts = map[string]string{
+	"newest": "coalesce(videos.uploaded, videos.created_at) desc, videos.id desc",
+	"oldest": "coalesce(videos.uploaded, videos.created_at), videos.id",
+	"views":  "videos.views desc, videos.id",
+	"rating": "videos.rating_score desc, videos.id",
+	"title":  "videos.title, videos.id",
+}
+
+type TagCount struct {
+	Tag    Tag `json:"tag"`
+	Videos int `json:"videos"`
+}
+
+type TubeCount struct {
+	Tube   Tube `json:"tube"`
+	Videos int  `json:"videos"`
+}
+
+type ActorStats struct {
+	Videos          int         `json:"videos"`
+	Views           int         `json:"views"`
+	AverageRating   float64     `json:"average_rating"`
+	RatingCount     int         `json:"rating_count"`
+	TopTags         []TagCount  `json:"top_tags"`
+	Tubes           []TubeCount `json:"tubes"`
+	FirstAppearance *time.Time  `json:"first_appearance"`
+	LastAppearance  *time.Time  `json:"last_appearance"`
+}
+
+var ActorVideosGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	by := r.URL.Query().Get("sort")
+	if by == "" {
+		by = "newest"
+	}
+	order, ok := filmographySorts[by]
+	if !ok {
+		http.Error(w, "Unknown sort "+by, http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	page := getPage(r)
+	videos := []Video{}
+	actorVideos(r, actor).Select("videos.*").Order(order).Offset(page * limit).Limit(limit).Find(&videos)
+	setUserRatings(currentUser(r), videos)
+	nav := getNavigation(len(videos), page, limit)
+
+	writeJSON(w, GetVideos{Nav: nav, Videos: videos})
+})
+
+var ActorStatsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rows := []struct {
+		ID          uint
+		Views       int
+		Rating      float64
+		RatingCount int
+		TubeID      uint
+		Uploaded    *time.Time
+		CreatedAt   time.Time
+	}{}
+	actorVideos(r, actor).
+		Select("videos.id, videos.views, videos.rating, videos.rating_count, videos.tube_id, videos.uploaded, videos.created_at").
+		Scan(&rows)
+
+	stats := ActorStats{Videos: len(rows), TopTags: []TagCount{}, Tubes: []TubeCount{}}
+	ids := []uint{}
+	tubes := map[uint]int{}
+	ratings := 0.0
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+		stats.Views += row.Views
+		stats.RatingCount += row.RatingCount
+		ratings += row.Rating * float64(row.RatingCount)
+		if row.TubeID != 0 {
+			tubes[row.TubeID]++
+		}
+
+		appeared := row.CreatedAt
+		if row.Uploaded != nil {
+			appeared = *row.Uploaded
+		}
+		if stats.FirstAppearance == nil || appeared.Before(*stats.FirstAppearance) {
+			first := appeared
+			stats.FirstAppearance = &first
+		}
+		if stats.LastAppearance == nil || appeared.After(*stats.LastAppearance) {
+			last := appeared
+			stats.LastAppearance = &last
+		}
+	}
+	if stats.RatingCount > 0 {
+		stats.AverageRating = ratings / float64(stats.RatingCount)
+	}
+
+	if len(ids) > 0 {
+		tags := []struct {
+			TagID uint
+			Count int
+		}{}
+		db.Table("video_tags").Select("tag_id, count(*) as count").Where("video_id IN (?)", ids).
+			Group("tag_id").Order("count desc, tag_id").Limit(ActorTopTags).Scan(&tags)
+		for _, t := range tags {
+			var tag Tag
+			if !db.First(&tag, t.TagID).RecordNotFound() {
+				stats.TopTags = append(stats.TopTags, TagCount{Tag: tag, Videos: t.Count})
+			}
+		}
+	}
+
+	for id, count := range tubes {
+		var tube Tube
+		if !db.First(&tube, id).RecordNotFound() {
+			stats.Tubes = append(stats.Tubes, TubeCount{Tube: tube, Videos: count})
+		}
+	}
+	sort.Slice(stats.Tubes, func(i, j int) bool {
+		if stats.Tubes[i].Videos != stats.Tubes[j].Videos {
+			return stats.Tubes[i].Videos > stats.Tubes[j].Videos
+		}
+		return stats.Tubes[i].Tube.ID < stats.Tubes[j].Tube.ID
+	})
+
+	writeJSON(w, stats)
+})
+
+// actorVideos returns a query on the videos of an actor the requester may see
+func actorVideos(r *http.Request, actor Actor) *gorm.DB {
+	return db.Model(&Video{}).Scopes(visibleVideos(r)).
+		Joins("JOIN video_actors ON video_actors.video_id = videos.id").
+		Where("video_actors.actor_id = ?", actor.ID)
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestActorFilmography(t *testing.T) {
+	Convey("Given an actor in three videos", t, func() {
+		setupTestSuite()
+		actor := Actor{Name: "Jane Doe"}
+		db.Create(&actor)
+		tube := Tube{Name: "Tube"}
+		db.Create(&tube)
+		outdoor := Tag{Name: "Outdoor"}
+		db.Create(&outdoor)
+		indoor := Tag{Name: "Indoor"}
+		db.Create(&indoor)
+		first := time.Date(2015, 3, 1, 0, 0, 0, 0, time.UTC)
+		last := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+		middle := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		db.Create(&Video{Title: "B", Views: 10, Rating: 4, RatingCount: 1, TubeID: tube.ID, Uploaded: &first,
+			Actors: []Actor{actor}, Tags: []Tag{outdoor, indoor}})
+		db.Create(&Video{Title: "A", Views: 30, Rating: 2, RatingCount: 3, TubeID: tube.ID, Uploaded: &last,
+			Actors: []Actor{actor}, Tags: []Tag{outdoor}})
+		db.Create(&Video{Title: "C", Views: 20, Uploaded: &middle, Actors: []Actor{actor}})
+		hidden := Video{Title: "hidden", Views: 1000, Actors: []Actor{actor}}
+		db.Create(&hidden)
+		db.Model(&hidden).UpdateColumn("hidden", true)
+		route := "/actors/" + fmt.Sprint(actor.ID)
+
+		Convey("When I call GET /actors/{id}/videos", func() {
+			response := doRequest("GET", route+"/videos", nil)
+			videos := GetVideos{}
+			json.Unmarshal(response.Body.Bytes(), &videos)
+
+			Convey("Then the visible videos should be listed newest first", func() {
+				So(len(videos.Videos), ShouldEqual, 3)
+				So(videos.Videos[0].Title, ShouldEqual, "A")
+				So(videos.Videos[2].Title, ShouldEqual, "B")
+			})
+		})
+
+		Convey("When I sort the videos by views", func() {
+			response := doRequest("GET", route+"/videos?sort=views", nil)
+			videos := GetVideos{}
+			json.Unmarshal(response.Body.Bytes(), &videos)
+
+			Convey("Then the most viewed should come first", func() {
+				So(videos.Videos[0].Title, ShouldEqual, "A")
+				So(videos.Videos[1].Title, ShouldEqual, "C")
+			})
+		})
+
+		Convey("When I use an unknown sort", func() {
+			response := doRequest("GET", route+"/videos?sort=length", nil)
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When I call GET /actors/{id}/stats", func() {
+			response := doRequest("GET", route+"/stats", nil)
+			stats := ActorStats{}
+			json.Unmarshal(response.Body.Bytes(), &stats)
+
+			Convey("Then the totals should cover the visible videos", func() {
+				So(stats.Videos, ShouldEqual, 3)
+				So(stats.Views, ShouldEqual, 60)
+				So(stats.RatingCount, ShouldEqual, 4)
+				So(stats.AverageRating, ShouldAlmostEqual, 2.5)
+			})
+
+			Convey("Then the top tags and tubes should be counted", func() {
+				So(stats.TopTags[0].Tag.Name, ShouldEqual, "Outdoor")
+				So(stats.TopTags[0].Videos, ShouldEqual, 2)
+				So(len(stats.Tubes), ShouldEqual, 1)
+				So(stats.Tubes[0].Videos, ShouldEqual, 2)
+			})
+
+			Convey("Then the first and last appearances should be known", func() {
+				So(stats.FirstAppearance.Equal(first), ShouldBeTrue)
+				So(stats.LastAppearance.Equal(last), ShouldBeTrue)
+			})
+		})
+	})
+}
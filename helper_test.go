@@ -20,16 +20,42 @@ var r http.Request
 
 func setupTestSuite() {
 	setupDB("sqlite3", "test.db")
-	db.Where("1 LIKE 1").Delete(Tube{})
-	db.Where("1 LIKE 1").Delete(Tag{})
-	db.Where("1 LIKE 1").Delete(Actor{})
-	db.Where("1 LIKE 1").Delete(Video{})
-	db.Where("1 LIKE 1").Delete(User{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Tube{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Tag{})
+	db.Unscoped().Where("1 LIKE 1").Delete(TagSynonym{})
+	db.Where("1 LIKE 1").Delete(TagStat{})
+	db.Where("1 LIKE 1").Delete(TagDailyCount{})
+	db.Where("1 LIKE 1").Delete(TagPair{})
+	db.Where("1 LIKE 1").Delete(CountedVideoTag{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Actor{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Video{})
+	db.Unscoped().Where("1 LIKE 1").Delete(User{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Rating{})
+	db.Where("1 LIKE 1").Delete(VideoRanking{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Watch{})
+	db.Where("1 LIKE 1").Delete(TubeSync{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Revision{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Report{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Comment{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Marker{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ActorAlias{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ActorRedirect{})
+	db.Unscoped().Where("1 LIKE 1").Delete(SocialLink{})
+	db.Unscoped().Where("1 LIKE 1").Delete(Photo{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ComplianceRecord{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ComplianceDocument{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ProductionRecord{})
+	db.Unscoped().Where("1 LIKE 1").Delete(ComplianceAudit{})
+	db.Exec("DELETE FROM video_tags")
+	db.Exec("DELETE FROM video_actors")
 }
 
 func doRequest(verb string, route string, body io.Reader) *httptest.ResponseRecorder {
+	return doRequestAs(User{Name: "me"}, verb, route, body)
+}
+
+func doRequestAs(user User, verb string, route string, body io.Reader) *httptest.ResponseRecorder {
 	m := setupRouter()
-	user := User{Name: "me"}
 	token := getToken(user)
 	request, _ := http.NewRequest(verb, route, body)
 	request.Header.Set("Authorization", "Bearer "+string(token))
@@ -40,6 +66,15 @@ func doRequest(verb string, route string, body io.Reader) *httptest.ResponseReco
 	return response
 }
 
+func doPublicRequest(verb string, route string, body io.Reader) *httptest.ResponseRecorder {
+	m := setupRouter()
+	request, _ := http.NewRequest(verb, route, body)
+	response := httptest.NewRecorder()
+	m.ServeHTTP(response, request)
+
+	return response
+}
+
 func createTubes(n int) {
 	i := 0
 	for i < n {
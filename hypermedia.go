@@ -5,6 +5,7 @@
 package main
 
 import (
+	"net/http"
 	"strconv"
 )
 
@@ -23,3 +24,13 @@ func getNavigation(n, page, limit int) Navigation {
 	}
 	return nav
 }
+
+// getPage returns the zero based page requested through the page query
+// parameter
+func getPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		return 0
+	}
+	return page
+}
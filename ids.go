@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// assignUUID is a create callback giving a UUID to every new record of a
+// model with a Uuid field
+func assignUUID(scope *gorm.Scope) {
+	if field, ok := scope.FieldByName("Uuid"); ok && field.IsBlank {
+		field.Set(newUUID())
+	}
+}
+
+// setupUUIDs registers the UUID callback, gives a UUID to rows created before
+// it existed and makes UUIDs unique for every given model. Callbacks are
+// shared by every connection, the callback is only registered once.
+func setupUUIDs(models ...interface{}) {
+	if db.Callback().Create().Get("baconcobra:assign_uuid") == nil {
+		db.Callback().Create().Before("gorm:create").Register("baconcobra:assign_uuid", assignUUID)
+	}
+
+	for _, model := range models {
+		scope := db.NewScope(model)
+		table := scope.TableName()
+
+		var ids []uint
+		db.Unscoped().Model(model).Where("uuid IS NULL OR uuid = ''").Pluck("id", &ids)
+		for _, id := range ids {
+			db.Unscoped().Model(model).Where("id = ?", id).UpdateColumn("uuid", newUUID())
+		}
+		db.Model(model).AddUniqueIndex("idx_"+table+"_uuid", "uuid")
+	}
+}
+
+// findByID loads the record with the given integer ID or UUID into out
+func findByID(query *gorm.DB, out interface{}, id string) error {
+	if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return query.First(out, id).Error
+	}
+	return query.Where("uuid = ?", id).First(out).Error
+}
+
+// publicUUIDsOnly tells whether integer IDs must be hidden from responses,
+// which is enabled by setting PUBLIC_IDS=uuid
+func publicUUIDsOnly() bool {
+	return os.Getenv("PUBLIC_IDS") == "uuid"
+}
+
+// writeJSON writes v as the JSON response, leaving integer IDs out when only
+// UUIDs are public and the original actor keys out for version 2
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status code other than 200
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	response, _ := json.Marshal(withActorVersion(v, actorVersion(w)))
+	if publicUUIDsOnly() {
+		var data interface{}
+		decoder := json.NewDecoder(bytes.NewReader(response))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err == nil {
+			response, _ = json.Marshal(stripIDs(data))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(response))
+}
+
+// stripIDs removes integer primary and foreign keys from decoded JSON
+func stripIDs(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			_, numeric := field.(json.Number)
+			if key == "ID" || (numeric && (key == "id" || strings.HasSuffix(key, "_id"))) {
+				delete(value, key)
+				continue
+			}
+			value[key] = stripIDs(field)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = stripIDs(value[i])
+		}
+	}
+	return data
+}
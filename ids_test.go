@@ -0,0 +1,137 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUUIDs(t *testing.T) {
+	Convey("Given no videos on the database", t, func() {
+		setupTestSuite()
+		Convey("When I call POST /videos with my own uuid", func() {
+			body := bytes.NewBufferString(`{"title": "foo", "uuid": "mine"}`)
+			response := doRequest("POST", "/videos", body)
+
+			Convey("Then the server should generate one", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.Uuid, ShouldNotEqual, "mine")
+				So(len(video.Uuid), ShouldEqual, 36)
+			})
+		})
+	})
+
+	Convey("Given a tag exists on the db", t, func() {
+		setupTestSuite()
+		tag := Tag{Name: "test"}
+		db.Create(&tag)
+
+		Convey("When I call GET /tags/{uuid}", func() {
+			response := doRequest("GET", "/tags/"+tag.Uuid, nil)
+
+			Convey("Then I should get the tag details", func() {
+				found := Tag{}
+				json.Unmarshal(response.Body.Bytes(), &found)
+				So(found.ID, ShouldEqual, tag.ID)
+				So(found.Uuid, ShouldEqual, tag.Uuid)
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When I call GET /tags/{uuid} with an unknown uuid", func() {
+			response := doRequest("GET", "/tags/"+newUUID(), nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+
+		Convey("When only UUIDs are public", func() {
+			os.Setenv("PUBLIC_IDS", "uuid")
+			response := doRequest("GET", "/tags", nil)
+			os.Unsetenv("PUBLIC_IDS")
+
+			Convey("Then integer ids should not be emitted", func() {
+				var data map[string][]map[string]interface{}
+				json.Unmarshal(response.Body.Bytes(), &data)
+				So(len(data["tags"]), ShouldEqual, 1)
+				So(data["tags"][0], ShouldNotContainKey, "ID")
+				So(data["tags"][0]["uuid"], ShouldEqual, tag.Uuid)
+			})
+		})
+	})
+
+	Convey("Given resources nested below a video, an actor and a tag", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		video := Video{Title: "test"}
+		db.Create(&video)
+		report := Report{VideoID: video.ID, UserID: 1, Reason: "broken", Status: ReportOpen}
+		db.Create(&report)
+		actor := Actor{Name: "Jane Doe", Aliases: []ActorAlias{{Name: "JD"}}}
+		db.Create(&actor)
+		tag := Tag{Name: "blonde"}
+		db.Create(&tag)
+		synonym := TagSynonym{TagID: tag.ID, Name: "blond", Slug: "blond"}
+		db.Create(&synonym)
+		recordRevision(0, RevisionCreate, "videos", video.ID, nil, video)
+		var revision Revision
+		db.Where("item_id = ?", video.ID).First(&revision)
+
+		Convey("Then every one of them should have a uuid", func() {
+			So(len(report.Uuid), ShouldEqual, 36)
+			So(len(actor.Aliases[0].Uuid), ShouldEqual, 36)
+			So(len(synonym.Uuid), ShouldEqual, 36)
+			So(len(revision.Uuid), ShouldEqual, 36)
+		})
+
+		Convey("When I address them by uuid", func() {
+			dismiss := doRequestAs(editor, "POST", "/reports/"+report.Uuid+"/dismiss", nil)
+			alias := doRequestAs(editor, "DELETE", "/actors/"+actor.Uuid+"/aliases/"+actor.Aliases[0].Uuid, nil)
+			removed := doRequestAs(editor, "DELETE", "/tags/"+tag.Uuid+"/synonyms/"+synonym.Uuid, nil)
+			revert := doRequestAs(editor, "POST", "/videos/"+video.Uuid+"/revisions/"+revision.Uuid+"/revert", nil)
+
+			Convey("Then they should be found", func() {
+				So(dismiss.Code, ShouldEqual, 200)
+				So(alias.Code, ShouldEqual, 200)
+				So(removed.Code, ShouldEqual, 200)
+				So(revert.Code, ShouldEqual, 200)
+			})
+		})
+	})
+
+	Convey("Given records kept alongside the resources", t, func() {
+		setupTestSuite()
+		records := []interface{}{
+			&Rating{VideoID: 1, UserID: 1, Value: 5},
+			&Watch{VideoID: 1, UserID: 1},
+			&TubeSync{TubeID: 1, Status: "running"},
+			&SocialLink{ActorID: 1, Type: "website", URL: "https://jane.example"},
+			&ComplianceRecord{ActorID: 1, LegalName: "Jane Doe"},
+			&ComplianceDocument{ActorID: 1, Type: "passport"},
+			&ProductionRecord{VideoID: 1, Producer: "Studio"},
+			&ComplianceAudit{UserID: 1, Action: "read"},
+		}
+		for _, record := range records {
+			db.Create(record)
+		}
+
+		Convey("Then every one of them should have a uuid", func() {
+			for _, record := range records {
+				field, _ := db.NewScope(record).FieldByName("Uuid")
+				So(len(field.Field.String()), ShouldEqual, 36)
+			}
+		})
+	})
+}
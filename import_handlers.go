@@ -0,0 +1,201 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// VideoImport is the body of a video import request
+type VideoImport struct {
+	URL string `json:"url"`
+}
+
+var (
+	errUnknownTube   = errors.New("no tube matches the URL")
+	errImportRefused = errors.New("only editors can refresh videos imported by others or published")
+)
+
+var VideoImportsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var t VideoImport
+	mapVideoImport(r, &t)
+
+	tube, err := findTubeForURL(t.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	author := currentUser(r)
+	video, created, err := importVideo(tube, t.URL, &author)
+	if err == errImportRefused {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if created {
+		writeJSONStatus(w, http.StatusCreated, video)
+		return
+	}
+	writeJSON(w, video)
+})
+
+// findTubeForURL returns the tube hosting the given URL
+func findTubeForURL(raw string) (Tube, error) {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return Tube{}, errors.New("invalid URL")
+	}
+	host := strings.ToLower(u.Hostname())
+
+	tubes := []Tube{}
+	db.Find(&tubes)
+	for _, tube := range tubes {
+		domain := tubeDomain(tube)
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return tube, nil
+		}
+	}
+	return Tube{}, errUnknownTube
+}
+
+// importVideo fetches the metadata of a video through the provider of its
+// tube, creating the video or refreshing it when it was already imported.
+// Videos imported by users other than editors go through review, while the
+// ones imported by the tube syncs, with a nil author, are published. Users
+// other than editors may only refresh their own videos still under review.
+func importVideo(tube Tube, target string, author *User) (video Video, created bool, err error) {
+	meta, err := metadataProvider(tube).Fetch(tube, target)
+	if err != nil {
+		return video, false, err
+	}
+
+	query := db.Where("tube_id = ?", tube.ID)
+	if meta.ExtID != "" {
+		query = query.Where("ext_id = ? OR url = ?", meta.ExtID, meta.URL)
+	} else {
+		query = query.Where("url = ?", meta.URL)
+	}
+	created = query.First(&video).RecordNotFound()
+
+	if !created && author != nil && !isEditor(*author) &&
+		(video.AuthorID != author.ID || video.Status == VideoPublished) {
+		return video, false, errImportRefused
+	}
+
+	var before interface{}
+	action := RevisionCreate
+	if !created {
+		before = video
+		action = RevisionUpdate
+	}
+	applyMetadata(&video, tube, meta)
+	sanitizeVideoEmbed(&video)
+	if created {
+		video.Status = publishStatus(video)
+		if author != nil {
+			video.AuthorID = author.ID
+			if !isEditor(*author) {
+				video.Status = VideoPendingReview
+			}
+		}
+	}
+	if err = db.Save(&video).Error; err != nil {
+		return video, created, err
+	}
+	if created && video.Status == VideoPublished {
+		emit(EventVideoPublished, video)
+	}
+	// Imports of the tube syncs are made by the server itself
+	var userID uint
+	if author != nil {
+		userID = author.ID
+	}
+	recordRevision(userID, action, "videos", video.ID, before, video)
+
+	tags := []Tag{}
+	for _, name := range meta.Tags {
+		tags = append(tags, findOrCreateTag(name))
+	}
+	actors := []Actor{}
+	for _, name := range meta.Actors {
+		actors = append(actors, findOrCreateActor(name))
+	}
+	if len(tags) > 0 {
+		db.Model(&video).Association("Tags").Append(tags)
+	}
+	if len(actors) > 0 {
+		db.Model(&video).Association("Actors").Append(actors)
+	}
+	autoTagVideo(video)
+	updateTagStats(video.ID)
+
+	db.Preload("Tags").Preload("Actors").First(&video, video.ID)
+	return video, created, nil
+}
+
+// applyMetadata copies the fields known by a provider onto a video, keeping
+// the current values of the fields the provider does not know about
+func applyMetadata(video *Video, tube Tube, meta VideoMetadata) {
+	video.TubeID = tube.ID
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&video.Title, meta.Title)
+	set(&video.URL, meta.URL)
+	set(&video.ExtID, meta.ExtID)
+	set(&video.Duration, meta.Duration)
+	set(&video.Embed, meta.Embed)
+	if len(meta.Thumbnails) > 0 {
+		video.MasterImage = meta.Thumbnails[0]
+		video.BigImages = strings.Join(meta.Thumbnails, ",")
+	}
+	if meta.Uploaded != nil {
+		video.Uploaded = meta.Uploaded
+	}
+}
+
+// findOrCreateTag returns the tag with the given name, slug or synonym,
+// creating it if needed
+func findOrCreateTag(name string) Tag {
+	tag, err := findTagByName(name)
+	if err != nil {
+		tag = Tag{Name: name}
+		db.Create(&tag)
+	}
+	return tag
+}
+
+// findOrCreateActor returns the actor with the given name or alias, creating
+// it if needed
+func findOrCreateActor(name string) Actor {
+	actor, err := findActorByName(name)
+	if err != nil {
+		actor = Actor{Name: name}
+		db.Create(&actor)
+	}
+	return actor
+}
+
+func mapVideoImport(r *http.Request, t *VideoImport) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
@@ -0,0 +1,208 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const fixturePage = `<html><head>
+<title>Fallback title</title>
+<meta property="og:title" content="Fixture &amp; video">
+<meta property="og:url" content="{{host}}/watch/abc123">
+<meta property="og:image" content="{{host}}/thumbs/1.jpg">
+<meta property="og:video:url" content="{{host}}/embed/abc123">
+<meta property="og:video:width" content="640">
+<meta property="og:video:height" content="360">
+<meta property="video:duration" content="754">
+<meta property="video:tag" content="Blonde">
+<meta property="video:tag" content="Outdoor">
+<meta property="video:actor" content="Jane Doe">
+<meta property="video:release_date" content="2016-05-04">
+</head><body></body></html>`
+
+type fixtureProvider struct{}
+
+func (p fixtureProvider) Fetch(tube Tube, target string) (VideoMetadata, error) {
+	return VideoMetadata{Title: "From provider", URL: target, ExtID: "42"}, nil
+}
+
+func TestPostVideoImports(t *testing.T) {
+	Convey("Given a tube serving OpenGraph video pages", t, func() {
+		setupTestSuite()
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page := bytes.Replace([]byte(fixturePage), []byte("{{host}}"), []byte(server.URL), -1)
+			w.Write(page)
+		}))
+		defer server.Close()
+		tube := Tube{Name: "fixture-og", URL: server.URL}
+		db.Create(&tube)
+		db.Create(&Tag{Name: "blonde"})
+		body := `{"url": "` + server.URL + `/watch/abc123"}`
+
+		Convey("When I call POST /videos/imports with a video URL", func() {
+			response := doRequest("POST", "/videos/imports", bytes.NewBufferString(body))
+
+			Convey("Then the video should be created from the page metadata", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.Title, ShouldEqual, "Fixture & video")
+				So(video.ExtID, ShouldEqual, "abc123")
+				So(video.Duration, ShouldEqual, "754")
+				So(video.TubeID, ShouldEqual, tube.ID)
+				So(video.MasterImage, ShouldEqual, server.URL+"/thumbs/1.jpg")
+				So(video.Embed, ShouldContainSubstring, server.URL+"/embed/abc123")
+				So(video.Uploaded, ShouldNotBeNil)
+				So(len(video.Tags), ShouldEqual, 2)
+				So(len(video.Actors), ShouldEqual, 1)
+			})
+
+			Convey("Then existing tags should be reused", func() {
+				count := 0
+				db.Model(&Tag{}).Where("lower(name) = ?", "blonde").Count(&count)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("And I should get a 201 response", func() {
+				So(response.Code, ShouldEqual, 201)
+			})
+
+			Convey("And the video should wait for review", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.Status, ShouldEqual, VideoPendingReview)
+			})
+		})
+
+		Convey("When an editor imports a video URL", func() {
+			editor := User{Name: "editor", Role: RoleEditor}
+			db.Create(&editor)
+			response := doRequestAs(editor, "POST", "/videos/imports", bytes.NewBufferString(body))
+
+			Convey("Then the video should be published with the editor as its author", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.Status, ShouldEqual, VideoPublished)
+				So(video.AuthorID, ShouldEqual, editor.ID)
+			})
+
+			Convey("Then the revision should be credited to the editor", func() {
+				var revision Revision
+				db.Where("item_type = ?", "videos").First(&revision)
+				So(revision.UserID, ShouldEqual, editor.ID)
+			})
+		})
+
+		Convey("When I import the same URL twice", func() {
+			doRequest("POST", "/videos/imports", bytes.NewBufferString(body))
+			response := doRequest("POST", "/videos/imports", bytes.NewBufferString(body))
+
+			Convey("Then the video should be refreshed instead of duplicated", func() {
+				count := 0
+				db.Model(&Video{}).Count(&count)
+				So(count, ShouldEqual, 1)
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When I import again a video published by an editor", func() {
+			editor := User{Name: "editor", Role: RoleEditor}
+			db.Create(&editor)
+			doRequestAs(editor, "POST", "/videos/imports", bytes.NewBufferString(body))
+			db.Model(&Video{}).UpdateColumn("title", "Edited title")
+			response := doRequest("POST", "/videos/imports", bytes.NewBufferString(body))
+
+			Convey("Then I should get a 403 response and the video should be kept", func() {
+				So(response.Code, ShouldEqual, 403)
+				video := Video{}
+				db.First(&video)
+				So(video.Title, ShouldEqual, "Edited title")
+				So(video.Status, ShouldEqual, VideoPublished)
+			})
+		})
+
+		Convey("When I import a URL of an unknown tube", func() {
+			response := doRequest("POST", "/videos/imports", bytes.NewBufferString(`{"url": "http://unknown.example/v/1"}`))
+
+			Convey("Then I should get a 422 response", func() {
+				So(response.Code, ShouldEqual, 422)
+			})
+		})
+	})
+
+	Convey("Given a video page pointing to another domain", t, func() {
+		setupTestSuite()
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page := bytes.Replace([]byte(fixturePage), []byte("{{host}}/watch"), []byte("http://evil.example/watch"), -1)
+			page = bytes.Replace(page, []byte("{{host}}"), []byte(server.URL), -1)
+			w.Write(page)
+		}))
+		defer server.Close()
+		tube := Tube{Name: "fixture-og", URL: server.URL}
+		db.Create(&tube)
+
+		Convey("When I fetch its metadata", func() {
+			meta, err := DefaultMetadataProvider.Fetch(tube, server.URL+"/watch/abc123")
+
+			Convey("Then the URL of the page should be kept", func() {
+				So(err, ShouldBeNil)
+				So(meta.URL, ShouldEqual, server.URL+"/watch/abc123")
+			})
+		})
+	})
+
+	Convey("Given a tube with a registered provider", t, func() {
+		setupTestSuite()
+		tube := Tube{Name: "fixture-custom", URL: "http://custom.example"}
+		db.Create(&tube)
+		RegisterMetadataProvider("Fixture-Custom", fixtureProvider{})
+
+		Convey("When I import one of its videos", func() {
+			body := bytes.NewBufferString(`{"url": "http://www.custom.example/v/42"}`)
+			response := doRequest("POST", "/videos/imports", body)
+
+			Convey("Then the registered provider should be used", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.Title, ShouldEqual, "From provider")
+				So(video.ExtID, ShouldEqual, "42")
+			})
+		})
+	})
+}
+
+func TestFindOrCreateTag(t *testing.T) {
+	Convey("Given a tag", t, func() {
+		setupTestSuite()
+		tag := Tag{Name: "Blonde"}
+		db.Create(&tag)
+
+		Convey("When a keyword equal to its ID is imported", func() {
+			found := findOrCreateTag(strconv.Itoa(int(tag.ID)))
+
+			Convey("Then a tag named after the keyword should be created", func() {
+				So(found.ID, ShouldNotEqual, tag.ID)
+				So(found.Name, ShouldEqual, strconv.Itoa(int(tag.ID)))
+			})
+		})
+
+		Convey("When its name is imported", func() {
+			found := findOrCreateTag("blonde")
+
+			Convey("Then it should be reused", func() {
+				So(found.ID, ShouldEqual, tag.ID)
+			})
+		})
+	})
+}
@@ -0,0 +1,210 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// LinkOK is the status of a video whose links answered
+	LinkOK = "ok"
+	// LinkFailing is the status of a video whose links failed fewer times
+	// than the limit
+	LinkFailing = "failing"
+	// LinkDead is the status of a video whose links failed too many times
+	LinkDead = "dead"
+
+	// HiddenDeadLink is the reason given to videos hidden by the link checker
+	HiddenDeadLink = "dead_link"
+)
+
+var errNoLinks = errors.New("video has no links")
+
+var VideoLinksGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	page := getPage(r)
+	videos := []Video{}
+	query := db.Where("link_checked IS NOT NULL")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("link_status = ?", status)
+	}
+	query.Order("link_checked desc").Offset(page * limit).Limit(limit).Find(&videos)
+	nav := getNavigation(len(videos), page, limit)
+
+	writeJSON(w, GetVideos{Nav: nav, Videos: videos})
+})
+
+// LinkChecker periodically probes the URL and embed of videos, recording
+// their status and hiding or flagging the ones that keep failing
+type LinkChecker struct {
+	Client *http.Client
+	// Concurrency is the number of probes running at the same time
+	Concurrency int
+	// HostInterval is the minimum time between two probes to the same host
+	HostInterval time.Duration
+	// MaxFailures is the number of consecutive failures before a video is
+	// considered dead
+	MaxFailures int
+	// Hide hides dead videos instead of only flagging them
+	Hide bool
+	// BatchSize is the number of videos checked on every run
+	BatchSize int
+	// RecheckAfter is the time before a video is checked again
+	RecheckAfter time.Duration
+
+	mu    sync.Mutex
+	slots map[string]time.Time
+}
+
+// NewLinkChecker returns a link checker configured from the environment
+func NewLinkChecker() *LinkChecker {
+	return &LinkChecker{
+		Client:       &http.Client{Timeout: 15 * time.Second},
+		Concurrency:  getEnvInt("LINKCHECK_CONCURRENCY", 4),
+		HostInterval: time.Duration(getEnvInt("LINKCHECK_HOST_INTERVAL_MS", 1000)) * time.Millisecond,
+		MaxFailures:  getEnvInt("LINKCHECK_MAX_FAILURES", 3),
+		Hide:         os.Getenv("LINKCHECK_DEAD_ACTION") == "hide",
+		BatchSize:    getEnvInt("LINKCHECK_BATCH_SIZE", 500),
+		RecheckAfter: time.Duration(getEnvInt("LINKCHECK_RECHECK_HOURS", 24)) * time.Hour,
+	}
+}
+
+// Start runs the checker on every tick of the given interval
+func (c *LinkChecker) Start(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.Run()
+	}
+}
+
+// Run checks a batch of the videos that are due for a check
+func (c *LinkChecker) Run() {
+	videos := []Video{}
+	db.Where("link_checked IS NULL OR link_checked < ?", time.Now().Add(-c.RecheckAfter)).
+		Order("link_checked").Limit(c.BatchSize).Find(&videos)
+
+	queue := make(chan Video)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for video := range queue {
+				c.record(video, c.check(video))
+			}
+		}()
+	}
+	for _, video := range videos {
+		queue <- video
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// check probes every link of a video and returns the first failure
+func (c *LinkChecker) check(video Video) error {
+	links := []string{}
+	if video.URL != "" {
+		links = append(links, video.URL)
+	}
+	for _, tag := range parseTags(video.Embed) {
+		if tag.Name == "iframe" && tag.Attrs["src"] != "" {
+			links = append(links, tag.Attrs["src"])
+		}
+	}
+
+	if len(links) == 0 {
+		return errNoLinks
+	}
+	for _, link := range links {
+		if err := c.probe(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probe requests a link, falling back to GET for servers refusing HEAD
+func (c *LinkChecker) probe(link string) error {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid link " + link)
+	}
+
+	c.wait(u.Host)
+	response, err := c.Client.Head(u.String())
+	if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+		response.Body.Close()
+		c.wait(u.Host)
+		response, err = c.Client.Get(u.String())
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("%s answered %d", link, response.StatusCode)
+	}
+	return nil
+}
+
+// wait blocks until the host can be probed again without exceeding its rate
+func (c *LinkChecker) wait(host string) {
+	c.mu.Lock()
+	if c.slots == nil {
+		c.slots = map[string]time.Time{}
+	}
+	now := time.Now()
+	slot := c.slots[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	c.slots[host] = slot.Add(c.HostInterval)
+	c.mu.Unlock()
+
+	time.Sleep(slot.Sub(now))
+}
+
+// record stores the outcome of a check on the video
+func (c *LinkChecker) record(video Video, failure error) {
+	now := time.Now()
+	fields := map[string]interface{}{"link_checked": &now}
+
+	if failure == errNoLinks {
+		fields["link_status"] = ""
+	} else if failure == nil {
+		fields["link_status"] = LinkOK
+		fields["link_failures"] = 0
+		if video.Hidden && video.HiddenReason == HiddenDeadLink {
+			fields["hidden"] = false
+			fields["hidden_reason"] = ""
+		}
+	} else {
+		failures := video.LinkFailures + 1
+		fields["link_failures"] = failures
+		fields["link_status"] = LinkFailing
+		if failures >= c.MaxFailures {
+			fields["link_status"] = LinkDead
+			if c.Hide && !video.Hidden {
+				fields["hidden"] = true
+				fields["hidden_reason"] = HiddenDeadLink
+			}
+		}
+		log.Printf("Link check of video %d failed: %s", video.ID, failure)
+	}
+
+	db.Model(&video).UpdateColumns(fields)
+	if _, ok := fields["hidden"]; ok {
+		updateTagStats(video.ID)
+	}
+}
@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLinkChecker(t *testing.T) {
+	Convey("Given videos pointing to a local tube stand-in", t, func() {
+		setupTestSuite()
+		tube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/ok":
+				w.WriteHeader(http.StatusOK)
+			case "/get-only":
+				if r.Method == "HEAD" {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer tube.Close()
+
+		db.Create(&Video{Title: "alive", URL: tube.URL + "/ok"})
+		db.Create(&Video{Title: "get only", URL: tube.URL + "/get-only"})
+		db.Create(&Video{Title: "dead", URL: tube.URL + "/ok", Embed: `<iframe src="` + tube.URL + `/gone"></iframe>`})
+
+		checker := &LinkChecker{
+			Client:       tube.Client(),
+			Concurrency:  2,
+			HostInterval: time.Millisecond,
+			MaxFailures:  2,
+			Hide:         true,
+			BatchSize:    10,
+		}
+
+		Convey("When the checker runs once", func() {
+			checker.Run()
+
+			Convey("Then every video should be checked", func() {
+				videos := []Video{}
+				db.Order("id").Find(&videos)
+				So(videos[0].LinkStatus, ShouldEqual, LinkOK)
+				So(videos[0].LinkChecked, ShouldNotBeNil)
+				So(videos[1].LinkStatus, ShouldEqual, LinkOK)
+				So(videos[2].LinkStatus, ShouldEqual, LinkFailing)
+				So(videos[2].LinkFailures, ShouldEqual, 1)
+				So(videos[2].Hidden, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the checker runs until the limit of failures", func() {
+			checker.Run()
+			checker.Run()
+
+			Convey("Then the dead video should be hidden", func() {
+				video := Video{}
+				db.Where("title = ?", "dead").First(&video)
+				So(video.LinkStatus, ShouldEqual, LinkDead)
+				So(video.Hidden, ShouldBeTrue)
+
+				response := doRequest("GET", "/videos", nil)
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 2)
+			})
+
+			Convey("And it should be listed on GET /videos/links for editors", func() {
+				response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "GET", "/videos/links?status=dead", nil)
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 1)
+				So(gt.Videos[0].Title, ShouldEqual, "dead")
+			})
+
+			Convey("And it should not be listed to users without a role", func() {
+				response := doRequest("GET", "/videos/links?status=dead", nil)
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+	})
+}
@@ -7,6 +7,8 @@ package main
 import (
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/lib/pq"
@@ -27,6 +29,22 @@ func main() {
 	} else {
 		setupDB("sqlite3", "dev.db")
 	}
+	// New videos can link actors who never played together
+	Subscribe(func(e Event) {
+		if e.Type == EventVideoPublished {
+			invalidateCostarGraph()
+		}
+	})
+	go startRankingScheduler(time.Duration(getEnvInt("RANKINGS_REFRESH_MINUTES", 15)) * time.Minute)
+	go startPublishScheduler(time.Duration(getEnvInt("PUBLISH_CHECK_MINUTES", 1)) * time.Minute)
+	go startTubeSyncScheduler(time.Duration(getEnvInt("SYNC_CHECK_MINUTES", 1)) * time.Minute)
+	if interval := getEnvInt("LINKCHECK_INTERVAL_MINUTES", 60); interval > 0 {
+		go NewLinkChecker().Start(time.Duration(interval) * time.Minute)
+	}
+	if days := getEnvInt("TRASH_RETENTION_DAYS", 30); days > 0 {
+		go startTrashPurger(time.Duration(days) * 24 * time.Hour)
+	}
+
 	r := setupRouter()
 	http.ListenAndServe(":"+os.Getenv("PORT"), handlers.LoggingHandler(os.Stdout, r))
 }
@@ -38,3 +56,13 @@ var NotImplemented = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reques
 var StatusHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("API is up and running"))
 })
+
+// getEnvInt reads an integer setting from the environment, falling back to
+// the given value when it is not set or invalid
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
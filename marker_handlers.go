@@ -0,0 +1,182 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Marker is a chapter or scene of a video, from Start to End in seconds. End
+// is 0 when the marker lasts until the next one. Tag and actor are optional.
+type Marker struct {
+	gorm.Model
+	Uuid    string `json:"uuid"`
+	VideoID uint   `json:"video_id" gorm:"index"`
+	Video   *Video `json:"video,omitempty"`
+	Start   int    `json:"start" gorm:"column:start_seconds"`
+	End     int    `json:"end" gorm:"column:end_seconds"`
+	Title   string `json:"title"`
+	TagID   uint   `json:"tag_id" gorm:"index"`
+	Tag     *Tag   `json:"tag,omitempty"`
+	ActorID uint   `json:"actor_id" gorm:"index"`
+	Actor   *Actor `json:"actor,omitempty"`
+}
+
+type GetMarkers struct {
+	Nav     Navigation `json:"nav"`
+	Markers []Marker   `json:"markers"`
+}
+
+var MarkersGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	markers := []Marker{}
+	db.Preload("Tag").Preload("Actor").Where("video_id = ?", video.ID).Order("start_seconds, id").Find(&markers)
+
+	writeJSON(w, GetMarkers{Markers: markers})
+})
+
+var MarkersPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t Marker
+	mapMarker(r, &t)
+	if err := validateMarker(video, t); err != "" {
+		http.Error(w, err, http.StatusBadRequest)
+		return
+	}
+	t.VideoID = video.ID
+	db.Create(&t)
+	db.Preload("Tag").Preload("Actor").First(&t, t.ID)
+
+	writeJSONStatus(w, http.StatusCreated, t)
+})
+
+var MarkerPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	var marker Marker
+	if getVideo(r, &video) != nil || getMarker(r, video, &marker) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t Marker
+	mapMarker(r, &t)
+	if err := validateMarker(video, t); err != "" {
+		http.Error(w, err, http.StatusBadRequest)
+		return
+	}
+	marker.Start = t.Start
+	marker.End = t.End
+	marker.Title = t.Title
+	marker.TagID = t.TagID
+	marker.ActorID = t.ActorID
+	db.Save(&marker)
+	db.Preload("Tag").Preload("Actor").First(&marker, marker.ID)
+
+	writeJSON(w, marker)
+})
+
+var MarkerDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	var marker Marker
+	if getVideo(r, &video) != nil || getMarker(r, video, &marker) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Delete(&marker)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// MarkersSearchHandler finds markers across the catalog by tag and actor,
+// given by ID, UUID or name
+var MarkersSearchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	query := db.Preload("Video").Preload("Tag").Preload("Actor").
+		Joins("JOIN videos ON videos.id = markers.video_id AND videos.deleted_at IS NULL").
+		Scopes(visibleVideos(r))
+
+	if name := r.URL.Query().Get("tag"); name != "" {
+		tag, err := findTag(name)
+		if err != nil {
+			writeJSON(w, GetMarkers{Markers: []Marker{}})
+			return
+		}
+		query = query.Where("markers.tag_id = ?", tag.ID)
+	}
+	if name := r.URL.Query().Get("actor"); name != "" {
+		actor, err := findActor(name)
+		if err != nil {
+			writeJSON(w, GetMarkers{Markers: []Marker{}})
+			return
+		}
+		query = query.Where("markers.actor_id = ?", actor.ID)
+	}
+
+	limit := 100
+	page := getPage(r)
+	markers := []Marker{}
+	query.Order("markers.video_id, markers.start_seconds").Offset(page * limit).Limit(limit).Find(&markers)
+	nav := getNavigation(len(markers), page, limit)
+
+	writeJSON(w, GetMarkers{Nav: nav, Markers: markers})
+})
+
+// validateMarker checks a marker against the duration of its video and the
+// tag and actor it refers to, returning the problem found if any
+func validateMarker(video Video, t Marker) string {
+	if t.Start < 0 {
+		return "Marker start must not be negative"
+	}
+	if t.End != 0 && t.End <= t.Start {
+		return "Marker end must come after its start"
+	}
+	if duration := durationSeconds(video.Duration); duration > 0 {
+		if t.Start >= duration || t.End > duration {
+			return fmt.Sprintf("Marker goes past the end of the video (%d seconds)", duration)
+		}
+	}
+	if t.TagID != 0 && db.First(&Tag{}, t.TagID).RecordNotFound() {
+		return "Unknown tag"
+	}
+	if t.ActorID != 0 && db.First(&Actor{}, t.ActorID).RecordNotFound() {
+		return "Unknown actor"
+	}
+	return ""
+}
+
+func getMarker(r *http.Request, video Video, marker *Marker) error {
+	return findByID(db.Where("video_id = ?", video.ID), marker, mux.Vars(r)["marker"])
+}
+
+func mapMarker(r *http.Request, t *Marker) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Uuid = ""
+	t.VideoID = 0
+	t.Video = nil
+	t.Tag = nil
+	t.Actor = nil
+}
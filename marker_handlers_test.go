@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarkers(t *testing.T) {
+	Convey("Given a ten minute video and a tag", t, func() {
+		setupTestSuite()
+		v := Video{Title: "test", Duration: "10:00"}
+		db.Create(&v)
+		tag := Tag{Name: "Intro"}
+		db.Create(&tag)
+		route := "/videos/" + fmt.Sprint(v.ID) + "/markers"
+
+		Convey("When I add markers out of order", func() {
+			doRequest("POST", route, bytes.NewBufferString(`{"title": "end", "start": 300}`))
+			response := doRequest("POST", route, bytes.NewBufferString(fmt.Sprintf(`{"title": "intro", "start": 0, "end": 60, "tag_id": %d}`, tag.ID)))
+
+			Convey("Then I should get a 201 response with the tag", func() {
+				marker := Marker{}
+				json.Unmarshal(response.Body.Bytes(), &marker)
+				So(response.Code, ShouldEqual, 201)
+				So(marker.Tag, ShouldNotBeNil)
+				So(marker.Tag.Name, ShouldEqual, "Intro")
+			})
+
+			Convey("Then they should be listed by start", func() {
+				markers := GetMarkers{}
+				json.Unmarshal(doRequest("GET", route, nil).Body.Bytes(), &markers)
+				So(len(markers.Markers), ShouldEqual, 2)
+				So(markers.Markers[0].Title, ShouldEqual, "intro")
+				So(markers.Markers[1].Title, ShouldEqual, "end")
+			})
+
+			Convey("Then I should find the marker by tag name", func() {
+				markers := GetMarkers{}
+				json.Unmarshal(doRequest("GET", "/markers?tag=intro", nil).Body.Bytes(), &markers)
+				So(len(markers.Markers), ShouldEqual, 1)
+				So(markers.Markers[0].Video.ID, ShouldEqual, v.ID)
+			})
+
+			Convey("When the video is not visible", func() {
+				db.Model(&v).UpdateColumn("hidden", true)
+
+				Convey("Then its markers should not be found", func() {
+					markers := GetMarkers{}
+					json.Unmarshal(doRequest("GET", "/markers?tag=intro", nil).Body.Bytes(), &markers)
+					So(len(markers.Markers), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I add a marker past the end of the video", func() {
+			response := doRequest("POST", route, bytes.NewBufferString(`{"title": "late", "start": 590, "end": 700}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When I add a marker ending before it starts", func() {
+			response := doRequest("POST", route, bytes.NewBufferString(`{"title": "bad", "start": 60, "end": 30}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When I add a marker with an unknown actor", func() {
+			response := doRequest("POST", route, bytes.NewBufferString(`{"title": "bad", "start": 60, "actor_id": 999999}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When I update and delete a marker", func() {
+			response := doRequest("POST", route, bytes.NewBufferString(`{"title": "intro", "start": 0}`))
+			marker := Marker{}
+			json.Unmarshal(response.Body.Bytes(), &marker)
+			markerRoute := route + "/" + fmt.Sprint(marker.ID)
+			patch := doRequest("PATCH", markerRoute, bytes.NewBufferString(`{"title": "opening", "start": 5, "end": 20}`))
+			updated := Marker{}
+			json.Unmarshal(patch.Body.Bytes(), &updated)
+			doRequest("DELETE", markerRoute, nil)
+
+			Convey("Then the update should be returned", func() {
+				So(updated.Title, ShouldEqual, "opening")
+				So(updated.End, ShouldEqual, 20)
+			})
+
+			Convey("Then the marker should be gone", func() {
+				So(db.First(&Marker{}, marker.ID).RecordNotFound(), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,181 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VideoMetadata is what a provider knows about a video of a tube
+type VideoMetadata struct {
+	Title      string
+	URL        string
+	ExtID      string
+	Duration   string
+	Embed      string
+	Thumbnails []string
+	Tags       []string
+	Actors     []string
+	Uploaded   *time.Time
+}
+
+// MetadataProvider turns the URL or ExtID of a video of a tube into its
+// metadata. Providers are registered by tube name with
+// RegisterMetadataProvider.
+type MetadataProvider interface {
+	Fetch(tube Tube, target string) (VideoMetadata, error)
+}
+
+var (
+	metadataProvidersMu sync.RWMutex
+	metadataProviders   = map[string]MetadataProvider{}
+)
+
+// DefaultMetadataProvider is used for tubes without a registered provider
+var DefaultMetadataProvider MetadataProvider = &OpenGraphProvider{
+	Client: &http.Client{Timeout: 15 * time.Second},
+}
+
+// RegisterMetadataProvider makes a provider available for the tube with the
+// given name, replacing any provider previously registered for it
+func RegisterMetadataProvider(tubeName string, provider MetadataProvider) {
+	metadataProvidersMu.Lock()
+	defer metadataProvidersMu.Unlock()
+	metadataProviders[strings.ToLower(tubeName)] = provider
+}
+
+// metadataProvider returns the provider registered for a tube
+func metadataProvider(tube Tube) MetadataProvider {
+	metadataProvidersMu.RLock()
+	defer metadataProvidersMu.RUnlock()
+	if provider, ok := metadataProviders[strings.ToLower(tube.Name)]; ok {
+		return provider
+	}
+	return DefaultMetadataProvider
+}
+
+// OpenGraphMaxSize is the largest video page read by the OpenGraph provider,
+// in bytes
+const OpenGraphMaxSize = 2 << 20
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// OpenGraphProvider reads the OpenGraph (https://ogp.me) meta tags of a video
+// page, which most tubes publish for social network previews
+type OpenGraphProvider struct {
+	Client *http.Client
+}
+
+func (p *OpenGraphProvider) Fetch(tube Tube, target string) (VideoMetadata, error) {
+	meta := VideoMetadata{}
+	u, err := url.Parse(target)
+	if err != nil || !u.IsAbs() {
+		return meta, errors.New("the OpenGraph provider needs the URL of the video page")
+	}
+
+	response, err := p.Client.Get(u.String())
+	if err != nil {
+		return meta, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return meta, fmt.Errorf("%s answered %d", target, response.StatusCode)
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(response.Body, OpenGraphMaxSize))
+	if err != nil {
+		return meta, err
+	}
+
+	meta.URL = u.String()
+	var player string
+	var width, height int
+	for _, tag := range parseTags(string(page)) {
+		if tag.Name != "meta" {
+			continue
+		}
+		property := tag.Attrs["property"]
+		if property == "" {
+			property = tag.Attrs["name"]
+		}
+		content := strings.TrimSpace(tag.Attrs["content"])
+		if content == "" {
+			continue
+		}
+
+		switch property {
+		case "og:title":
+			meta.Title = content
+		case "og:url":
+			// A page may only point to videos of its own tube
+			if canonical, ok := embedSource(content, tubeDomain(tube)); ok {
+				meta.URL = canonical
+			}
+		case "og:image", "og:image:url", "og:image:secure_url":
+			meta.Thumbnails = appendUnique(meta.Thumbnails, content)
+		case "og:video", "og:video:url", "og:video:secure_url":
+			if player == "" || strings.HasPrefix(content, "https:") {
+				player = content
+			}
+		case "og:video:width":
+			width, _ = strconv.Atoi(content)
+		case "og:video:height":
+			height, _ = strconv.Atoi(content)
+		case "video:duration":
+			meta.Duration = content
+		case "video:tag":
+			meta.Tags = appendUnique(meta.Tags, content)
+		case "video:actor":
+			meta.Actors = appendUnique(meta.Actors, content)
+		case "video:release_date":
+			if uploaded, err := time.Parse(time.RFC3339, content); err == nil {
+				meta.Uploaded = &uploaded
+			} else if uploaded, err := time.Parse("2006-01-02", content); err == nil {
+				meta.Uploaded = &uploaded
+			}
+		}
+	}
+
+	if meta.Title == "" {
+		if m := titlePattern.FindStringSubmatch(string(page)); m != nil {
+			meta.Title = strings.TrimSpace(html.UnescapeString(m[1]))
+		}
+	}
+	if canonical, err := url.Parse(meta.URL); err == nil {
+		meta.ExtID = path.Base(strings.TrimSuffix(canonical.Path, "/"))
+		if meta.ExtID == "." || meta.ExtID == "/" {
+			meta.ExtID = ""
+		}
+	}
+	if player != "" {
+		attrs := map[string]string{"src": player, "frameborder": "0", "allowfullscreen": ""}
+		if width > 0 && height > 0 {
+			attrs["width"] = strconv.Itoa(width)
+			attrs["height"] = strconv.Itoa(height)
+		}
+		meta.Embed = renderIframe(htmlTag{Name: "iframe", Attrs: attrs})
+	}
+	return meta, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// VideoDraft is a video still being worked on
+	VideoDraft = "draft"
+	// VideoPendingReview is a video waiting for an editor
+	VideoPendingReview = "pending_review"
+	// VideoScheduled is an approved video waiting for its upload time
+	VideoScheduled = "scheduled"
+	// VideoPublished is a video visible to everybody
+	VideoPublished = "published"
+	// VideoRejected is a video an editor turned down
+	VideoRejected = "rejected"
+	// VideoArchived is a video taken out of the catalog
+	VideoArchived = "archived"
+)
+
+// videoTransitions lists the statuses a video can move to from each status
+var videoTransitions = map[string][]string{
+	VideoDraft:         {VideoPendingReview, VideoArchived},
+	VideoPendingReview: {VideoPublished, VideoScheduled, VideoRejected, VideoDraft},
+	VideoScheduled:     {VideoPublished, VideoArchived, VideoDraft},
+	VideoPublished:     {VideoArchived, VideoDraft},
+	VideoRejected:      {VideoDraft, VideoPendingReview},
+	VideoArchived:      {VideoPublished, VideoDraft},
+}
+
+// Review is the body of a moderation request
+type Review struct {
+	Note string `json:"note"`
+}
+
+var VideoSubmitHandler = videoTransitionHandler(VideoPendingReview)
+
+var VideoApproveHandler = videoTransitionHandler(VideoPublished)
+
+var VideoRejectHandler = videoTransitionHandler(VideoRejected)
+
+var VideoArchiveHandler = videoTransitionHandler(VideoArchived)
+
+// videoTransitionHandler moves a video to the given status, recording the
+// reviewer and their note. Authors can move their own videos, editors can
+// move any video.
+func videoTransitionHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var video Video
+		if getVideo(r, &video) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		u := currentUser(r)
+		if !isEditor(u) && (u.ID == 0 || video.AuthorID != u.ID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		to := status
+		if to == VideoPublished {
+			to = publishStatus(video)
+		}
+		if !canTransition(video.Status, to) {
+			http.Error(w, "Cannot move a "+video.Status+" video to "+to, http.StatusConflict)
+			return
+		}
+
+		var t Review
+		mapReview(r, &t)
+		now := time.Now()
+		video.Status = to
+		video.ReviewNote = t.Note
+		video.ReviewedBy = u.ID
+		video.ReviewedAt = &now
+		db.Model(&video).UpdateColumns(map[string]interface{}{
+			"status":      video.Status,
+			"review_note": video.ReviewNote,
+			"reviewed_by": video.ReviewedBy,
+			"reviewed_at": video.ReviewedAt,
+		})
+		updateTagStats(video.ID)
+		if video.Status == VideoPublished {
+			emit(EventVideoPublished, video)
+		}
+
+		writeJSON(w, video)
+	}
+}
+
+// canTransition tells whether a video can move between two statuses
+func canTransition(from, to string) bool {
+	for _, status := range videoTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+func mapReview(r *http.Request, t *Review) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
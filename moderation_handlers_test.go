@@ -0,0 +1,103 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVideoModeration(t *testing.T) {
+	Convey("Given a contributor and an editor", t, func() {
+		setupTestSuite()
+		alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+		editor := User{Model: gorm.Model{ID: 2}, Name: "editor", Role: RoleEditor}
+
+		Convey("When the contributor calls POST /videos", func() {
+			response := doRequestAs(alice, "POST", "/videos", bytes.NewBufferString(`{"title": "test", "status": "published"}`))
+			video := Video{}
+			json.Unmarshal(response.Body.Bytes(), &video)
+			id := fmt.Sprint(video.ID)
+
+			Convey("Then the video should wait for review", func() {
+				So(video.Status, ShouldEqual, VideoPendingReview)
+				So(video.AuthorID, ShouldEqual, 1)
+			})
+
+			Convey("Then it should be hidden from other users", func() {
+				response := doRequest("GET", "/videos/"+id, nil)
+				So(response.Code, ShouldEqual, 404)
+			})
+
+			Convey("Then its author should still see it", func() {
+				response := doRequestAs(alice, "GET", "/videos/"+id, nil)
+				So(response.Code, ShouldEqual, 200)
+			})
+
+			Convey("Then the contributor should not be able to approve it", func() {
+				response := doRequestAs(alice, "POST", "/videos/"+id+"/approve", bytes.NewBufferString(`{}`))
+				So(response.Code, ShouldEqual, 403)
+			})
+
+			Convey("Then editors should find it in the review queue", func() {
+				response := doRequestAs(editor, "GET", "/videos?status=pending_review", nil)
+				videos := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &videos)
+				So(len(videos.Videos), ShouldEqual, 1)
+			})
+
+			Convey("When an editor approves it", func() {
+				response := doRequestAs(editor, "POST", "/videos/"+id+"/approve", bytes.NewBufferString(`{"note": "looks good"}`))
+
+				Convey("Then the review should be recorded", func() {
+					v := Video{}
+					db.First(&v, video.ID)
+					So(response.Code, ShouldEqual, 200)
+					So(v.Status, ShouldEqual, VideoPublished)
+					So(v.ReviewNote, ShouldEqual, "looks good")
+					So(v.ReviewedBy, ShouldEqual, 2)
+					So(v.ReviewedAt, ShouldNotBeNil)
+				})
+
+				Convey("Then it should be visible to other users", func() {
+					response := doRequest("GET", "/videos/"+id, nil)
+					So(response.Code, ShouldEqual, 200)
+				})
+			})
+
+			Convey("When an editor rejects it and the author submits it again", func() {
+				doRequestAs(editor, "POST", "/videos/"+id+"/reject", bytes.NewBufferString(`{"note": "no embed"}`))
+				response := doRequestAs(alice, "POST", "/videos/"+id+"/submit", bytes.NewBufferString(`{}`))
+
+				Convey("Then it should wait for review again", func() {
+					v := Video{}
+					db.First(&v, video.ID)
+					So(response.Code, ShouldEqual, 200)
+					So(v.Status, ShouldEqual, VideoPendingReview)
+				})
+			})
+		})
+
+		Convey("When an editor calls POST /videos", func() {
+			response := doRequestAs(editor, "POST", "/videos", bytes.NewBufferString(`{"title": "test"}`))
+			video := Video{}
+			json.Unmarshal(response.Body.Bytes(), &video)
+
+			Convey("Then the video should be published right away", func() {
+				So(video.Status, ShouldEqual, VideoPublished)
+			})
+
+			Convey("Then submitting it should be refused", func() {
+				response := doRequestAs(editor, "POST", "/videos/"+fmt.Sprint(video.ID)+"/submit", bytes.NewBufferString(`{}`))
+				So(response.Code, ShouldEqual, 409)
+			})
+		})
+	})
+}
@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// OEmbedProviderName is the provider name advertised on oEmbed responses
+	OEmbedProviderName = "BaconCobra"
+	// OEmbedDefaultWidth is used when an embed does not declare its width
+	OEmbedDefaultWidth = 640
+	// OEmbedDefaultHeight is used when an embed does not declare its height
+	OEmbedDefaultHeight = 360
+)
+
+var oembedVideoPath = regexp.MustCompile(`/videos/([0-9a-fA-F-]+)/?$`)
+
+// OEmbed is a video type response as described on https://oembed.com
+type OEmbed struct {
+	XMLName      xml.Name `json:"-" xml:"oembed"`
+	Type         string   `json:"type" xml:"type"`
+	Version      string   `json:"version" xml:"version"`
+	Title        string   `json:"title,omitempty" xml:"title,omitempty"`
+	ProviderName string   `json:"provider_name" xml:"provider_name"`
+	ProviderURL  string   `json:"provider_url,omitempty" xml:"provider_url,omitempty"`
+	ThumbnailURL string   `json:"thumbnail_url,omitempty" xml:"thumbnail_url,omitempty"`
+	HTML         string   `json:"html" xml:"html"`
+	Width        int      `json:"width" xml:"width"`
+	Height       int      `json:"height" xml:"height"`
+}
+
+var OEmbedGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "xml" {
+		http.Error(w, "Format not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	id, ok := oembedVideoID(query.Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var video Video
+	if findByID(db.Scopes(visibleVideos(r)), &video, id) != nil || video.Embed == "" {
+		http.NotFound(w, r)
+		return
+	}
+	sanitizeVideoEmbed(&video)
+	if video.Embed == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	maxWidth, _ := strconv.Atoi(query.Get("maxwidth"))
+	maxHeight, _ := strconv.Atoi(query.Get("maxheight"))
+	oembed := videoOEmbed(video, maxWidth, maxHeight)
+
+	if format == "xml" {
+		response, _ := xml.Marshal(oembed)
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		w.Write(response)
+		return
+	}
+	response, _ := json.Marshal(oembed)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response))
+})
+
+// oembedVideoID extracts the video id from one of our video URLs. When
+// BASE_URL is set the URL must also point to that host.
+func oembedVideoID(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return "", false
+	}
+	if base, err := url.Parse(os.Getenv("BASE_URL")); err == nil && base.Host != "" {
+		if !strings.EqualFold(u.Host, base.Host) {
+			return "", false
+		}
+	}
+	m := oembedVideoPath.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// videoOEmbed builds the oEmbed response of a video, scaling the player down
+// to fit within the given maximum size
+func videoOEmbed(video Video, maxWidth, maxHeight int) OEmbed {
+	width, height := embedSize(video.Embed)
+	if width == 0 || height == 0 {
+		width, height = OEmbedDefaultWidth, OEmbedDefaultHeight
+	}
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+	width = int(float64(width) * scale)
+	height = int(float64(height) * scale)
+
+	return OEmbed{
+		Type:         "video",
+		Version:      "1.0",
+		Title:        video.Title,
+		ProviderName: OEmbedProviderName,
+		ProviderURL:  os.Getenv("BASE_URL"),
+		ThumbnailURL: video.MasterImage,
+		HTML:         resizeEmbed(video.Embed, width, height),
+		Width:        width,
+		Height:       height,
+	}
+}
+
+// resizeEmbed sets the size of every iframe of a sanitized embed
+func resizeEmbed(embed string, width, height int) string {
+	markup := ""
+	for _, tag := range parseTags(embed) {
+		tag.Attrs["width"] = strconv.Itoa(width)
+		tag.Attrs["height"] = strconv.Itoa(height)
+		markup += renderIframe(tag)
+	}
+	return markup
+}
@@ -0,0 +1,99 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetOEmbed(t *testing.T) {
+	Convey("Given a video with an embed exists on the db", t, func() {
+		setupTestSuite()
+		tube := Tube{Name: "tube", URL: "http://www.tube.com"}
+		db.Create(&tube)
+		v := Video{
+			Title:  "test",
+			TubeID: tube.ID,
+			Embed:  `<iframe src="http://www.tube.com/embed/1" width="800" height="600"></iframe>`,
+		}
+		db.Create(&v)
+		url := fmt.Sprintf("http://bc.example/videos/%d", v.ID)
+
+		Convey("When I call GET /oembed", func() {
+			response := doPublicRequest("GET", "/oembed?url="+url+"&maxwidth=400", nil)
+
+			Convey("Then I should get a scaled video oEmbed response", func() {
+				oembed := OEmbed{}
+				json.Unmarshal(response.Body.Bytes(), &oembed)
+				So(oembed.Type, ShouldEqual, "video")
+				So(oembed.Version, ShouldEqual, "1.0")
+				So(oembed.Title, ShouldEqual, "test")
+				So(oembed.Width, ShouldEqual, 400)
+				So(oembed.Height, ShouldEqual, 300)
+				So(oembed.HTML, ShouldEqual, `<iframe src="http://www.tube.com/embed/1" width="400" height="300"></iframe>`)
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When I call GET /oembed in xml", func() {
+			response := doPublicRequest("GET", "/oembed?format=xml&url="+url, nil)
+
+			Convey("Then I should get a xml oEmbed response", func() {
+				oembed := OEmbed{}
+				xml.Unmarshal(response.Body.Bytes(), &oembed)
+				So(oembed.Type, ShouldEqual, "video")
+				So(oembed.Width, ShouldEqual, 800)
+			})
+		})
+
+		Convey("When I call GET /oembed with an unsupported format", func() {
+			response := doPublicRequest("GET", "/oembed?format=yaml&url="+url, nil)
+
+			Convey("Then I should get a 501 response", func() {
+				So(response.Code, ShouldEqual, 501)
+			})
+		})
+
+		Convey("When I call GET /oembed for an unknown video", func() {
+			response := doPublicRequest("GET", "/oembed?url=http://bc.example/videos/0", nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+	})
+}
+
+func TestPostVideoEmbed(t *testing.T) {
+	Convey("Given a tube exists on the db", t, func() {
+		setupTestSuite()
+		tube := Tube{Name: "tube", URL: "http://www.tube.com"}
+		db.Create(&tube)
+
+		Convey("When I call POST /videos with an unsafe embed", func() {
+			data, _ := json.Marshal(Video{
+				Title:  "foo",
+				TubeID: tube.ID,
+				Embed:  `<iframe src="http://tube.com/e/1" onload="steal()"></iframe><script>steal()</script>`,
+			})
+			doRequest("POST", "/videos", bytes.NewBuffer(data))
+
+			Convey("Then the embed should be stored sanitized", func() {
+				video := Video{}
+				db.First(&video)
+				So(video.Embed, ShouldEqual, `<iframe src="http://tube.com/e/1"></iframe>`)
+			})
+		})
+	})
+}
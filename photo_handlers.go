@@ -0,0 +1,325 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// PhotoMaxSize is the largest photo that can be uploaded, in bytes
+	PhotoMaxSize = 10 << 20
+	// PhotoMaxPixels is the largest number of pixels of an uploaded photo,
+	// which bounds the memory taken to decode it
+	PhotoMaxPixels = 50000000
+	// ThumbnailSize is the largest side of photo thumbnails, in pixels
+	ThumbnailSize = 320
+)
+
+// Photo is a picture of an actor. Photos are listed by Position and the
+// Primary one illustrates the actor in listings.
+type Photo struct {
+	gorm.Model
+	Uuid         string `json:"uuid"`
+	ActorID      uint   `json:"actor_id" gorm:"index"`
+	Position     int    `json:"position"`
+	Primary      bool   `json:"primary" gorm:"column:is_primary;not null;default:false"`
+	File         string `json:"-"`
+	Thumbnail    string `json:"-"`
+	Format       string `json:"format"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	URL          string `json:"url" gorm:"-"`
+	ThumbnailURL string `json:"thumbnail_url" gorm:"-"`
+}
+
+type GetPhotos struct {
+	Photos []Photo `json:"photos"`
+}
+
+// PhotoUpdate is the body of a photo update, moving it in the gallery or
+// making it the primary photo
+type PhotoUpdate struct {
+	Position *int `json:"position"`
+	Primary  bool `json:"primary"`
+}
+
+var PhotosGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, GetPhotos{Photos: actorPhotos(actor.ID)})
+})
+
+// PhotosPostHandler stores an image sent as the "photo" field of a multipart
+// form, or as the request body, along with its thumbnail
+var PhotosPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := readPhoto(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Small files can declare huge images, which are refused before being
+	// decoded
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Unsupported image", http.StatusUnsupportedMediaType)
+		return
+	}
+	if int64(config.Width)*int64(config.Height) > PhotoMaxPixels {
+		http.Error(w, fmt.Sprintf("Photo is larger than %d pixels", PhotoMaxPixels), http.StatusRequestEntityTooLarge)
+		return
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Unsupported image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	photo := Photo{
+		ActorID: actor.ID,
+		Uuid:    newUUID(),
+		Format:  format,
+		Width:   img.Bounds().Dx(),
+		Height:  img.Bounds().Dy(),
+	}
+	photo.File = fmt.Sprintf("actors/%s/%s.%s", actor.Uuid, photo.Uuid, format)
+	photo.Thumbnail = fmt.Sprintf("actors/%s/%s_thumb.jpg", actor.Uuid, photo.Uuid)
+
+	var thumb bytes.Buffer
+	jpeg.Encode(&thumb, thumbnail(img, ThumbnailSize), &jpeg.Options{Quality: 85})
+	if err := PhotoStorage.Save(photo.File, bytes.NewReader(data)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := PhotoStorage.Save(photo.Thumbnail, &thumb); err != nil {
+		PhotoStorage.Delete(photo.File)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var count int
+	db.Model(&Photo{}).Where("actor_id = ?", actor.ID).Count(&count)
+	photo.Position = count
+	photo.Primary = count == 0
+	db.Create(&photo)
+	setPhotoURLs(&photo)
+
+	writeJSONStatus(w, http.StatusCreated, photo)
+})
+
+var PhotoPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	var photo Photo
+	if getActor(r, &actor) != nil || getPhoto(r, actor, &photo) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t PhotoUpdate
+	mapPhotoUpdate(r, &t)
+	tx := db.Begin()
+	if t.Position != nil {
+		photos := []Photo{}
+		tx.Where("actor_id = ?", actor.ID).Order("position, id").Find(&photos)
+		order := []uint{}
+		for _, p := range photos {
+			if p.ID != photo.ID {
+				order = append(order, p.ID)
+			}
+		}
+		position := *t.Position
+		if position < 0 {
+			position = 0
+		}
+		if position > len(order) {
+			position = len(order)
+		}
+		order = append(order[:position], append([]uint{photo.ID}, order[position:]...)...)
+		for i, id := range order {
+			tx.Model(&Photo{}).Where("id = ?", id).UpdateColumn("position", i)
+		}
+	}
+	if t.Primary {
+		tx.Model(&Photo{}).Where("actor_id = ?", actor.ID).UpdateColumn("is_primary", false)
+		tx.Model(&Photo{}).Where("id = ?", photo.ID).UpdateColumn("is_primary", true)
+	}
+	tx.Commit()
+
+	db.First(&photo, photo.ID)
+	setPhotoURLs(&photo)
+	writeJSON(w, photo)
+})
+
+var PhotoDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	var photo Photo
+	if getActor(r, &actor) != nil || getPhoto(r, actor, &photo) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The files go away with the photo, so it is not kept in the trash
+	db.Unscoped().Delete(&photo)
+	PhotoStorage.Delete(photo.File)
+	PhotoStorage.Delete(photo.Thumbnail)
+
+	photos := actorPhotos(actor.ID)
+	for i, p := range photos {
+		db.Model(&p).UpdateColumn("position", i)
+	}
+	if photo.Primary && len(photos) > 0 {
+		db.Model(&photos[0]).UpdateColumn("is_primary", true)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// readPhoto returns the uploaded image, limited to PhotoMaxSize
+func readPhoto(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, PhotoMaxSize+1<<20)
+	var data io.Reader = r.Body
+	if err := r.ParseMultipartForm(PhotoMaxSize); err == nil {
+		file, _, err := r.FormFile("photo")
+		if err != nil {
+			return nil, fmt.Errorf("missing photo field")
+		}
+		defer file.Close()
+		data = file
+	} else if err != http.ErrNotMultipart {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(data, PhotoMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > PhotoMaxSize {
+		return nil, fmt.Errorf("photo is larger than %d bytes", PhotoMaxSize)
+	}
+	return content, nil
+}
+
+// thumbnail scales an image down so that its largest side is at most max
+// pixels, averaging the pixels each thumbnail pixel covers
+func thumbnail(img image.Image, max int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= max && height <= max {
+		max = width
+		if height > width {
+			max = height
+		}
+	}
+	tw, th := max, height*max/width
+	if height > width {
+		tw, th = width*max/height, max
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*height/th, bounds.Min.Y+(y+1)*height/th
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*width/tw, bounds.Min.X+(x+1)*width/tw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			thumb.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return thumb
+}
+
+// actorPhotos returns the photos of an actor in gallery order
+func actorPhotos(actorID uint) []Photo {
+	photos := []Photo{}
+	db.Where("actor_id = ?", actorID).Order("position, id").Find(&photos)
+	for i := range photos {
+		setPhotoURLs(&photos[i])
+	}
+	return photos
+}
+
+// setPrimaryPhotos fills the primary photo of the given actors
+func setPrimaryPhotos(actors []Actor) {
+	if len(actors) == 0 {
+		return
+	}
+	ids := make([]uint, len(actors))
+	for i, actor := range actors {
+		ids[i] = actor.ID
+	}
+	photos := []Photo{}
+	db.Where("actor_id IN (?) AND is_primary = ?", ids, true).Find(&photos)
+	primary := map[uint]*Photo{}
+	for i := range photos {
+		setPhotoURLs(&photos[i])
+		primary[photos[i].ActorID] = &photos[i]
+	}
+	for i := range actors {
+		actors[i].PrimaryPhoto = primary[actors[i].ID]
+	}
+}
+
+func setPhotoURLs(photo *Photo) {
+	photo.URL = PhotoStorage.URL(photo.File)
+	photo.ThumbnailURL = PhotoStorage.URL(photo.Thumbnail)
+}
+
+func getPhoto(r *http.Request, actor Actor, photo *Photo) error {
+	return findByID(db.Where("actor_id = ?", actor.ID), photo, mux.Vars(r)["photo"])
+}
+
+func mapPhotoUpdate(r *http.Request, t *PhotoUpdate) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
@@ -0,0 +1,180 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func photoUpload(width, height int) (*bytes.Buffer, string) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{200, 100, 50, 255})
+		}
+	}
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("photo", "photo.png")
+	png.Encode(part, img)
+	form.Close()
+	return body, form.FormDataContentType()
+}
+
+func uploadPhoto(actor Actor, width, height int) *httptest.ResponseRecorder {
+	body, contentType := photoUpload(width, height)
+	request, _ := http.NewRequest("POST", fmt.Sprintf("/actors/%d/photos", actor.ID), body)
+	request.Header.Set("Authorization", "Bearer "+string(getToken(User{Name: "me"})))
+	request.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+	setupRouter().ServeHTTP(response, request)
+	return response
+}
+
+func TestActorPhotos(t *testing.T) {
+	Convey("Given an actor and a local photo storage", t, func() {
+		setupTestSuite()
+		dir, _ := ioutil.TempDir("", "photos")
+		defer os.RemoveAll(dir)
+		defer func(s Storage) { PhotoStorage = s }(PhotoStorage)
+		PhotoStorage = &LocalStorage{Dir: dir, BaseURL: "/files/"}
+		actor := Actor{Name: "Jane Doe"}
+		db.Create(&actor)
+		id := fmt.Sprint(actor.ID)
+
+		Convey("When I upload a photo", func() {
+			response := uploadPhoto(actor, 800, 400)
+			photo := Photo{}
+			json.Unmarshal(response.Body.Bytes(), &photo)
+
+			Convey("Then it should be stored with its thumbnail", func() {
+				So(response.Code, ShouldEqual, 201)
+				So(photo.Format, ShouldEqual, "png")
+				So(photo.Width, ShouldEqual, 800)
+				So(photo.Primary, ShouldBeTrue)
+				So(photo.URL, ShouldStartWith, "/files/actors/")
+
+				file, err := os.Open(filepath.Join(dir, strings.TrimPrefix(photo.ThumbnailURL, "/files/")))
+				So(err, ShouldBeNil)
+				defer file.Close()
+				thumb, err := jpeg.Decode(file)
+				So(err, ShouldBeNil)
+				So(thumb.Bounds().Dx(), ShouldEqual, ThumbnailSize)
+				So(thumb.Bounds().Dy(), ShouldEqual, ThumbnailSize/2)
+			})
+
+			Convey("Then the file should be served without a token", func() {
+				response := doPublicRequest("GET", photo.URL, nil)
+				So(response.Code, ShouldEqual, 200)
+			})
+
+			Convey("Then the directories should not be listed", func() {
+				So(doPublicRequest("GET", "/files/", nil).Code, ShouldEqual, 404)
+				So(doPublicRequest("GET", "/files/actors/", nil).Code, ShouldEqual, 404)
+				So(doPublicRequest("GET", "/files/actors/"+actor.Uuid, nil).Code, ShouldEqual, 404)
+			})
+
+			Convey("Then it should be the primary photo of the actor in listings", func() {
+				response := doRequest("GET", "/actors", nil)
+				actors := GetActors{}
+				json.Unmarshal(response.Body.Bytes(), &actors)
+				So(actors.Actors[0].PrimaryPhoto, ShouldNotBeNil)
+				So(actors.Actors[0].PrimaryPhoto.Uuid, ShouldEqual, photo.Uuid)
+			})
+
+			Convey("When I upload a second photo and make it primary at the front", func() {
+				response := uploadPhoto(actor, 100, 100)
+				second := Photo{}
+				json.Unmarshal(response.Body.Bytes(), &second)
+				So(second.Primary, ShouldBeFalse)
+				So(second.Position, ShouldEqual, 1)
+
+				doRequest("PATCH", "/actors/"+id+"/photos/"+second.Uuid, bytes.NewBufferString(`{"position": 0, "primary": true}`))
+				response = doRequest("GET", "/actors/"+id+"/photos", nil)
+				photos := GetPhotos{}
+				json.Unmarshal(response.Body.Bytes(), &photos)
+
+				Convey("Then the gallery should be reordered", func() {
+					So(len(photos.Photos), ShouldEqual, 2)
+					So(photos.Photos[0].Uuid, ShouldEqual, second.Uuid)
+					So(photos.Photos[0].Primary, ShouldBeTrue)
+					So(photos.Photos[1].Primary, ShouldBeFalse)
+				})
+
+				Convey("When I delete the primary photo", func() {
+					doRequest("DELETE", "/actors/"+id+"/photos/"+second.Uuid, nil)
+					remaining := actorPhotos(actor.ID)
+
+					Convey("Then the next photo should become primary and its files removed", func() {
+						So(len(remaining), ShouldEqual, 1)
+						So(remaining[0].Primary, ShouldBeTrue)
+						So(remaining[0].Position, ShouldEqual, 0)
+						_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(second.URL, "/files/"))))
+						So(os.IsNotExist(err), ShouldBeTrue)
+					})
+				})
+			})
+		})
+
+		Convey("When I upload something that is not an image", func() {
+			request, _ := http.NewRequest("POST", "/actors/"+id+"/photos", bytes.NewBufferString("not an image"))
+			request.Header.Set("Authorization", "Bearer "+string(getToken(User{Name: "me"})))
+			response := httptest.NewRecorder()
+			setupRouter().ServeHTTP(response, request)
+
+			Convey("Then I should get a 415 response", func() {
+				So(response.Code, ShouldEqual, 415)
+			})
+		})
+
+		Convey("When I upload a small image declaring huge dimensions", func() {
+			// A GIF header for a 65535x65535 screen without any pixel data
+			header := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+			request, _ := http.NewRequest("POST", "/actors/"+id+"/photos", bytes.NewReader(header))
+			request.Header.Set("Authorization", "Bearer "+string(getToken(User{Name: "me"})))
+			response := httptest.NewRecorder()
+			setupRouter().ServeHTTP(response, request)
+
+			Convey("Then I should get a 413 response", func() {
+				So(response.Code, ShouldEqual, 413)
+			})
+		})
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	Convey("Given a local storage", t, func() {
+		dir, _ := ioutil.TempDir("", "storage")
+		defer os.RemoveAll(dir)
+		storage := &LocalStorage{Dir: dir, BaseURL: "/files/"}
+
+		Convey("Then keys leaving the directory should be refused", func() {
+			So(storage.Save("../outside", strings.NewReader("x")), ShouldEqual, errInvalidKey)
+			So(storage.Save("a/../../outside", strings.NewReader("x")), ShouldEqual, errInvalidKey)
+		})
+
+		Convey("Then saved files should be deleted", func() {
+			So(storage.Save("a/b.txt", strings.NewReader("x")), ShouldBeNil)
+			So(storage.Delete("a/b.txt"), ShouldBeNil)
+			So(storage.Delete("a/b.txt"), ShouldBeNil)
+		})
+	})
+}
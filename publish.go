@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// publishStatus returns the status a video gets when it is published, videos
+// uploaded in the future wait for their time as scheduled
+func publishStatus(video Video) string {
+	if video.Uploaded != nil && video.Uploaded.After(time.Now()) {
+		return VideoScheduled
+	}
+	return VideoPublished
+}
+
+// countedUntil is the upload time up to which the published videos have been
+// counted in the tag statistics
+var countedUntil = time.Now()
+
+// publishScheduledVideos makes the scheduled videos whose time has come
+// public and returns how many went live. Published videos uploaded in the
+// future are counted in the tag statistics once their time has come.
+func publishScheduledVideos() int {
+	now := time.Now()
+	var uploaded []uint
+	db.Model(&Video{}).Where("status = ? AND uploaded > ? AND uploaded <= ?", VideoPublished, countedUntil, now).
+		Pluck("id", &uploaded)
+	updateTagStats(uploaded...)
+	countedUntil = now
+
+	videos := []Video{}
+	db.Where("status = ? AND uploaded <= ?", VideoScheduled, time.Now()).Find(&videos)
+	n := 0
+	for _, video := range videos {
+		// Only the first of concurrent schedulers gets to publish a video
+		query := db.Model(&Video{}).Where("id = ? AND status = ?", video.ID, VideoScheduled).
+			UpdateColumn("status", VideoPublished)
+		if query.Error != nil || query.RowsAffected == 0 {
+			continue
+		}
+		video.Status = VideoPublished
+		updateTagStats(video.ID)
+		emit(EventVideoPublished, video)
+		n++
+	}
+	return n
+}
+
+// startPublishScheduler publishes the scheduled videos on every tick of the
+// given interval
+func startPublishScheduler(interval time.Duration) {
+	for range time.Tick(interval) {
+		if n := publishScheduledVideos(); n > 0 {
+			log.Printf("Published %d scheduled videos", n)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduledPublishing(t *testing.T) {
+	published := []Video{}
+	Subscribe(func(e Event) {
+		if e.Type == EventVideoPublished {
+			published = append(published, e.Data.(Video))
+		}
+	})
+
+	Convey("Given an editor posted a video uploaded in the future", t, func() {
+		setupTestSuite()
+		published = published[:0]
+		editor := User{Name: "editor", Role: RoleEditor}
+		later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		response := doRequestAs(editor, "POST", "/videos", bytes.NewBufferString(`{"title": "soon", "uploaded": "`+later+`"}`))
+		video := Video{}
+		json.Unmarshal(response.Body.Bytes(), &video)
+		id := fmt.Sprint(video.ID)
+
+		Convey("Then the video should be scheduled", func() {
+			So(video.Status, ShouldEqual, VideoScheduled)
+			So(len(published), ShouldEqual, 0)
+		})
+
+		Convey("Then other users should not see it", func() {
+			So(doRequest("GET", "/videos/"+id, nil).Code, ShouldEqual, 404)
+			videos := GetVideos{}
+			json.Unmarshal(doRequest("GET", "/videos", nil).Body.Bytes(), &videos)
+			So(len(videos.Videos), ShouldEqual, 0)
+		})
+
+		Convey("When the scheduler runs before its time", func() {
+			n := publishScheduledVideos()
+
+			Convey("Then nothing should be published", func() {
+				So(n, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When its time comes and the scheduler runs", func() {
+			db.Model(&Video{}).Where("id = ?", video.ID).UpdateColumn("uploaded", time.Now().Add(-time.Minute))
+			n := publishScheduledVideos()
+
+			Convey("Then the video should be published", func() {
+				So(n, ShouldEqual, 1)
+				So(doRequest("GET", "/videos/"+id, nil).Code, ShouldEqual, 200)
+			})
+
+			Convey("Then a change event should be emitted", func() {
+				So(len(published), ShouldEqual, 1)
+				So(published[0].ID, ShouldEqual, video.ID)
+				So(published[0].Status, ShouldEqual, VideoPublished)
+			})
+		})
+	})
+
+	Convey("Given a published video uploaded in the future", t, func() {
+		setupTestSuite()
+		later := time.Now().Add(time.Hour)
+		v := Video{Title: "test", Uploaded: &later}
+		db.Create(&v)
+
+		Convey("When another user calls GET /videos/{id}", func() {
+			response := doRequest("GET", "/videos/"+fmt.Sprint(v.ID), nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+	})
+}
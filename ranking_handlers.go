@@ -0,0 +1,201 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// TrendingRanking ranks videos by engagement decayed with their age
+	TrendingRanking = "trending"
+	// PopularRanking ranks videos by raw engagement
+	PopularRanking = "popular"
+
+	// RankingSize is the number of videos stored per ranking and window
+	RankingSize = 500
+	// RankingRatingWeight is the number of views a single rating is worth
+	RankingRatingWeight = 10
+	// RankingGravity controls how fast trending scores decay with age
+	RankingGravity = 1.5
+)
+
+// RankingWindows are the supported time windows, a zero duration stands for
+// all time
+var RankingWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// DefaultRankingWindow is used when no window is requested
+const DefaultRankingWindow = "week"
+
+type VideoRanking struct {
+	ID       uint    `json:"-" gorm:"primary_key"`
+	Kind     string  `json:"kind" gorm:"index:idx_ranking"`
+	Window   string  `json:"window" gorm:"column:time_window;index:idx_ranking"`
+	Position int     `json:"position" gorm:"index:idx_ranking"`
+	VideoID  uint    `json:"video_id"`
+	Score    float64 `json:"score"`
+}
+
+var TrendingVideosGetHandler = rankingHandler(TrendingRanking)
+
+var PopularVideosGetHandler = rankingHandler(PopularRanking)
+
+func rankingHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := r.URL.Query().Get("window")
+		if window == "" {
+			window = DefaultRankingWindow
+		}
+		if _, ok := RankingWindows[window]; !ok {
+			http.Error(w, "Unknown window", http.StatusBadRequest)
+			return
+		}
+
+		limit := 100
+		page := getPage(r)
+		rankings := []VideoRanking{}
+		db.Where("kind = ? AND time_window = ?", kind, window).
+			Order("position").Offset(page * limit).Limit(limit).Find(&rankings)
+
+		videos := rankedVideos(r, rankings)
+		setUserRatings(currentUser(r), videos)
+		nav := getNavigation(len(rankings), page, limit)
+
+		writeJSON(w, GetVideos{Nav: nav, Videos: videos})
+	}
+}
+
+// rankedVideos loads the videos of the given rankings keeping their order
+func rankedVideos(r *http.Request, rankings []VideoRanking) []Video {
+	videos := []Video{}
+	if len(rankings) == 0 {
+		return videos
+	}
+
+	ids := make([]uint, len(rankings))
+	for i, ranking := range rankings {
+		ids[i] = ranking.VideoID
+	}
+	found := []Video{}
+	db.Scopes(visibleVideos(r)).Where("id IN (?)", ids).Find(&found)
+
+	byID := make(map[uint]Video, len(found))
+	for _, v := range found {
+		byID[v.ID] = v
+	}
+	for _, id := range ids {
+		if v, ok := byID[id]; ok {
+			videos = append(videos, v)
+		}
+	}
+	return videos
+}
+
+// rankingCandidate holds the columns needed to score a video
+type rankingCandidate struct {
+	ID          uint
+	Views       int
+	RatingCount int
+	RatingScore float64
+	Uploaded    *time.Time
+	CreatedAt   time.Time
+}
+
+func (c rankingCandidate) publishedAt() time.Time {
+	if c.Uploaded != nil {
+		return *c.Uploaded
+	}
+	return c.CreatedAt
+}
+
+func (c rankingCandidate) engagement() float64 {
+	return float64(c.Views) + RankingRatingWeight*float64(c.RatingCount)*c.RatingScore/MaxRating
+}
+
+func popularScore(c rankingCandidate, now time.Time) float64 {
+	return c.engagement()
+}
+
+func trendingScore(c rankingCandidate, now time.Time) float64 {
+	age := now.Sub(c.publishedAt()).Hours()
+	if age < 0 {
+		age = 0
+	}
+	return c.engagement() / math.Pow(age+2, RankingGravity)
+}
+
+// refreshRankings recomputes every ranking for every window
+func refreshRankings() {
+	now := time.Now()
+	scorers := map[string]func(rankingCandidate, time.Time) float64{
+		TrendingRanking: trendingScore,
+		PopularRanking:  popularScore,
+	}
+
+	tx := db.Begin()
+	tx.Delete(VideoRanking{})
+	for window, duration := range RankingWindows {
+		candidates := rankingCandidates(tx, window, duration, now)
+		for kind, scorer := range scorers {
+			storeRanking(tx, kind, window, candidates, scorer, now)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Println("Could not refresh rankings:", err)
+	}
+}
+
+func rankingCandidates(tx *gorm.DB, window string, duration time.Duration, now time.Time) []rankingCandidate {
+	candidates := []rankingCandidate{}
+	// Only public videos are ranked, so that every stored position can be
+	// shown and videos uploaded in the future do not trend right away
+	query := tx.Model(&Video{}).Select("id, views, rating_count, rating_score, uploaded, created_at").
+		Scopes(publicVideos)
+	if duration > 0 {
+		since := now.Add(-duration)
+		query = query.Where("uploaded >= ? OR (uploaded IS NULL AND created_at >= ?)", since, since)
+	}
+	query.Scan(&candidates)
+	return candidates
+}
+
+func storeRanking(tx *gorm.DB, kind, window string, candidates []rankingCandidate, scorer func(rankingCandidate, time.Time) float64, now time.Time) {
+	rankings := make([]VideoRanking, len(candidates))
+	for i, c := range candidates {
+		rankings[i] = VideoRanking{Kind: kind, Window: window, VideoID: c.ID, Score: scorer(c, now)}
+	}
+	sort.SliceStable(rankings, func(i, j int) bool {
+		return rankings[i].Score > rankings[j].Score
+	})
+	if len(rankings) > RankingSize {
+		rankings = rankings[:RankingSize]
+	}
+	for i := range rankings {
+		rankings[i].Position = i
+		tx.Create(&rankings[i])
+	}
+}
+
+// startRankingScheduler refreshes the rankings right away and then on every
+// tick of the given interval
+func startRankingScheduler(interval time.Duration) {
+	refreshRankings()
+	for range time.Tick(interval) {
+		refreshRankings()
+	}
+}
@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetTrendingVideos(t *testing.T) {
+	Convey("Given an old popular video and a fresh one", t, func() {
+		setupTestSuite()
+		old := time.Now().Add(-20 * 24 * time.Hour)
+		fresh := time.Now().Add(-2 * time.Hour)
+		db.Create(&Video{Title: "old", Views: 10000, Uploaded: &old})
+		db.Create(&Video{Title: "fresh", Views: 500, Uploaded: &fresh})
+		refreshRankings()
+
+		Convey("When I call GET /videos/trending", func() {
+			response := doRequest("GET", "/videos/trending?window=month", nil)
+
+			Convey("Then the fresh video should come first", func() {
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 2)
+				So(gt.Videos[0].Title, ShouldEqual, "fresh")
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When I call GET /videos/popular", func() {
+			response := doRequest("GET", "/videos/popular?window=month", nil)
+
+			Convey("Then the most viewed video should come first", func() {
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 2)
+				So(gt.Videos[0].Title, ShouldEqual, "old")
+			})
+		})
+
+		Convey("When I call GET /videos/popular for the last week", func() {
+			response := doRequest("GET", "/videos/popular?window=week", nil)
+
+			Convey("Then videos outside the window should be left out", func() {
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 1)
+				So(gt.Videos[0].Title, ShouldEqual, "fresh")
+			})
+		})
+
+		Convey("When videos which are not public are ranked", func() {
+			soon := time.Now().Add(time.Hour)
+			db.Create(&Video{Title: "hidden", Views: 20000, Hidden: true})
+			db.Create(&Video{Title: "pending", Views: 20000, Status: VideoPendingReview})
+			db.Create(&Video{Title: "future", Views: 20000, Uploaded: &soon})
+			refreshRankings()
+
+			Convey("Then they should be left out of the rankings", func() {
+				var n int
+				db.Model(&VideoRanking{}).Where("kind = ? AND time_window = ?", TrendingRanking, "all").Count(&n)
+				So(n, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I call GET /videos/trending with an unknown window", func() {
+			response := doRequest("GET", "/videos/trending?window=decade", nil)
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+	})
+}
@@ -0,0 +1,184 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// MinRating is the lowest value a user can rate a video with
+	MinRating = 1
+	// MaxRating is the highest value a user can rate a video with
+	MaxRating = 5
+	// RatingPriorWeight is the number of virtual votes at the global mean
+	// added to every video when computing its bayesian score
+	RatingPriorWeight = 10
+)
+
+type Rating struct {
+	gorm.Model
+	Uuid    string `json:"uuid"`
+	VideoID uint   `json:"video_id" gorm:"unique_index:idx_rating_video_user"`
+	UserID  uint   `json:"user_id" gorm:"unique_index:idx_rating_video_user"`
+	Value   int    `json:"value"`
+}
+
+type GetRating struct {
+	Average    float64 `json:"average"`
+	Count      int     `json:"count"`
+	Score      float64 `json:"score"`
+	UserRating int     `json:"user_rating,omitempty"`
+}
+
+var RatingGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, GetRating{
+		Average:    video.Rating,
+		Count:      video.RatingCount,
+		Score:      video.RatingScore,
+		UserRating: getUserRating(currentUser(r), video.ID),
+	})
+})
+
+var RatingsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Ratings are counted once per user, a token without a user would let
+	// anyone rate for everyone else
+	user := currentUser(r)
+	if user.ID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t Rating
+	mapRating(r, &t)
+	if t.Value < MinRating || t.Value > MaxRating {
+		http.Error(w, "Rating value out of range", http.StatusBadRequest)
+		return
+	}
+
+	tx := db.Begin()
+	var rating Rating
+	tx.Where("video_id = ? AND user_id = ?", video.ID, user.ID).First(&rating)
+	rating.VideoID = video.ID
+	rating.UserID = user.ID
+	rating.Value = t.Value
+	tx.Save(&rating)
+	updateVideoRating(tx, &video)
+	tx.Commit()
+
+	video.UserRating = rating.Value
+	writeJSON(w, video)
+})
+
+var RatingDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.ID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	tx := db.Begin()
+	// Ratings are hard deleted so the user can rate the video again
+	tx.Unscoped().Where("video_id = ? AND user_id = ?", video.ID, user.ID).Delete(Rating{})
+	updateVideoRating(tx, &video)
+	tx.Commit()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// updateVideoRating recomputes the rating aggregates of a video from its
+// ratings and stores them on the video. The global mean every score is
+// pulled towards changes along, so the scores of the other videos are
+// recomputed from their stored aggregates.
+func updateVideoRating(tx *gorm.DB, video *Video) {
+	var count, sum int
+	tx.Model(&Rating{}).Where("video_id = ?", video.ID).
+		Select("count(*), coalesce(sum(value), 0)").Row().Scan(&count, &sum)
+
+	// The global mean is the prior every video starts from, videos with
+	// few ratings are pulled towards it
+	var total, totalSum int
+	tx.Model(&Rating{}).Select("count(*), coalesce(sum(value), 0)").Row().Scan(&total, &totalSum)
+	mean := float64(MinRating+MaxRating) / 2
+	if total > 0 {
+		mean = float64(totalSum) / float64(total)
+	}
+
+	video.RatingCount = count
+	video.Rating = 0
+	if count > 0 {
+		video.Rating = float64(sum) / float64(count)
+	}
+	video.RatingScore = (RatingPriorWeight*mean + float64(sum)) / float64(RatingPriorWeight+count)
+
+	tx.Model(video).UpdateColumns(map[string]interface{}{
+		"rating":       video.Rating,
+		"rating_count": video.RatingCount,
+		"rating_score": video.RatingScore,
+	})
+	tx.Exec("UPDATE videos SET rating_score = (? * ? + rating * rating_count) / (? + rating_count)",
+		float64(RatingPriorWeight), mean, float64(RatingPriorWeight))
+}
+
+// getUserRating returns the rating the given user gave to a video, or 0 if
+// the user has not rated it
+func getUserRating(u User, videoID uint) int {
+	var rating Rating
+	db.Where("video_id = ? AND user_id = ?", videoID, u.ID).First(&rating)
+	return rating.Value
+}
+
+// setUserRatings fills the user rating of a list of videos
+func setUserRatings(u User, videos []Video) {
+	if len(videos) == 0 {
+		return
+	}
+	ids := make([]uint, len(videos))
+	for i, v := range videos {
+		ids[i] = v.ID
+	}
+
+	ratings := []Rating{}
+	db.Where("user_id = ? AND video_id IN (?)", u.ID, ids).Find(&ratings)
+	values := make(map[uint]int, len(ratings))
+	for _, rating := range ratings {
+		values[rating.VideoID] = rating.Value
+	}
+	for i := range videos {
+		videos[i].UserRating = values[videos[i].ID]
+	}
+}
+
+func mapRating(r *http.Request, t *Rating) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Uuid = ""
+}
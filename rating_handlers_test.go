@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostRatings(t *testing.T) {
+	Convey("Given a video exists on the db", t, func() {
+		setupTestSuite()
+		v := Video{Title: "test"}
+		db.Create(&v)
+		id := fmt.Sprint(v.ID)
+		alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+		bob := User{Model: gorm.Model{ID: 2}, Name: "bob"}
+
+		Convey("When two users call POST /videos/{id}/ratings", func() {
+			doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 5}`))
+			response := doRequestAs(bob, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 2}`))
+
+			Convey("Then one rating per user should be stored", func() {
+				ratings := []Rating{}
+				db.Where("video_id = ?", v.ID).Find(&ratings)
+				So(len(ratings), ShouldEqual, 2)
+			})
+
+			Convey("Then the video should hold the aggregated rating", func() {
+				video := Video{}
+				db.Find(&video, v.ID)
+				So(video.Rating, ShouldEqual, 3.5)
+				So(video.RatingCount, ShouldEqual, 2)
+				So(video.RatingScore, ShouldAlmostEqual, 3.5)
+			})
+
+			Convey("Then I should get my own rating back", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.UserRating, ShouldEqual, 2)
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When another video is rated", func() {
+			other := Video{Title: "other"}
+			db.Create(&other)
+			doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 5}`))
+			doRequestAs(bob, "POST", fmt.Sprintf("/videos/%d/ratings", other.ID), bytes.NewBufferString(`{"value": 1}`))
+
+			Convey("Then the score of the video should follow the global mean", func() {
+				video := Video{}
+				db.Find(&video, v.ID)
+				So(video.RatingScore, ShouldAlmostEqual, (RatingPriorWeight*3.0+5)/(RatingPriorWeight+1))
+			})
+		})
+
+		Convey("When the same user rates the video twice", func() {
+			doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 5}`))
+			doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 1}`))
+
+			Convey("Then only the last rating should count", func() {
+				video := Video{}
+				db.Find(&video, v.ID)
+				So(video.Rating, ShouldEqual, 1)
+				So(video.RatingCount, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I rate the video out of range", func() {
+			response := doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 9}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When a token without a user rates the video", func() {
+			response := doRequest("POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 5}`))
+
+			Convey("Then I should get a 401 response", func() {
+				So(response.Code, ShouldEqual, 401)
+				So(doRequest("DELETE", "/videos/"+id+"/ratings", nil).Code, ShouldEqual, 401)
+			})
+		})
+	})
+}
+
+func TestGetVideoUserRating(t *testing.T) {
+	Convey("Given a video rated by a user", t, func() {
+		setupTestSuite()
+		v := Video{Title: "test"}
+		db.Create(&v)
+		id := fmt.Sprint(v.ID)
+		alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+		doRequestAs(alice, "POST", "/videos/"+id+"/ratings", bytes.NewBufferString(`{"value": 4}`))
+
+		Convey("When the user calls GET /videos/{id}", func() {
+			response := doRequestAs(alice, "GET", "/videos/"+id, nil)
+
+			Convey("Then the user rating should be visible", func() {
+				video := Video{}
+				json.Unmarshal(response.Body.Bytes(), &video)
+				So(video.UserRating, ShouldEqual, 4)
+				So(video.RatingCount, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the user calls DELETE /videos/{id}/ratings", func() {
+			response := doRequestAs(alice, "DELETE", "/videos/"+id+"/ratings", nil)
+
+			Convey("Then the aggregate should be reset", func() {
+				video := Video{}
+				db.Find(&video, v.ID)
+				So(video.RatingCount, ShouldEqual, 0)
+				So(video.Rating, ShouldEqual, 0)
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+	})
+}
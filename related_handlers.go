@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// RelatedTagWeight is the score of every tag shared with a video
+	RelatedTagWeight = 3
+	// RelatedActorWeight is the score of every actor shared with a video
+	RelatedActorWeight = 5
+	// RelatedTubeWeight is the score of coming from the same tube
+	RelatedTubeWeight = 2
+	// RelatedDurationWeight is the score of having the exact same duration,
+	// it decreases linearly as durations differ
+	RelatedDurationWeight = 1
+	// RelatedTubeCandidates caps the number of same tube videos considered
+	RelatedTubeCandidates = 200
+)
+
+// Watch records that a user has watched a video
+type Watch struct {
+	gorm.Model
+	Uuid    string `json:"uuid"`
+	VideoID uint   `json:"video_id" gorm:"index"`
+	UserID  uint   `json:"user_id" gorm:"index"`
+}
+
+// relatedCandidate holds the columns needed to score a related video
+type relatedCandidate struct {
+	ID       uint
+	TubeID   uint
+	Duration string
+	score    float64
+}
+
+var RelatedVideosGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit := 20
+	page := getPage(r)
+	candidates := relatedCandidates(r, video)
+	if r.URL.Query().Get("exclude_watched") == "true" {
+		candidates = excludeWatched(currentUser(r), candidates)
+	}
+
+	ids := []uint{}
+	for i := page * limit; i < len(candidates) && i < (page+1)*limit; i++ {
+		ids = append(ids, candidates[i].ID)
+	}
+	videos := []Video{}
+	if len(ids) > 0 {
+		found := []Video{}
+		db.Scopes(visibleVideos(r)).Where("id IN (?)", ids).Find(&found)
+		byID := make(map[uint]Video, len(found))
+		for _, v := range found {
+			byID[v.ID] = v
+		}
+		for _, id := range ids {
+			if v, ok := byID[id]; ok {
+				videos = append(videos, v)
+			}
+		}
+	}
+	setUserRatings(currentUser(r), videos)
+	nav := getNavigation(len(videos), page, limit)
+
+	writeJSON(w, GetVideos{Nav: nav, Videos: videos})
+})
+
+// relatedCandidates returns the videos related to the given one sorted by
+// descending score
+func relatedCandidates(r *http.Request, video Video) []relatedCandidate {
+	scores := map[uint]float64{}
+	addOverlap(scores, "video_tags", "tag_id", video.ID, RelatedTagWeight)
+	addOverlap(scores, "video_actors", "actor_id", video.ID, RelatedActorWeight)
+
+	if video.TubeID != 0 {
+		var sameTube []uint
+		db.Model(&Video{}).Where("tube_id = ? AND id <> ?", video.TubeID, video.ID).
+			Order("id desc").Limit(RelatedTubeCandidates).Pluck("id", &sameTube)
+		for _, id := range sameTube {
+			if _, ok := scores[id]; !ok {
+				scores[id] = 0
+			}
+		}
+	}
+	if len(scores) == 0 {
+		return []relatedCandidate{}
+	}
+
+	ids := make([]uint, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	candidates := []relatedCandidate{}
+	db.Scopes(visibleVideos(r)).Model(&Video{}).Select("id, tube_id, duration").Where("id IN (?)", ids).Scan(&candidates)
+
+	duration := durationSeconds(video.Duration)
+	for i := range candidates {
+		c := &candidates[i]
+		c.score = scores[c.ID]
+		if video.TubeID != 0 && c.TubeID == video.TubeID {
+			c.score += RelatedTubeWeight
+		}
+		c.score += RelatedDurationWeight * durationSimilarity(duration, durationSeconds(c.Duration))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].ID > candidates[j].ID
+	})
+	return candidates
+}
+
+// addOverlap adds weight to the score of every video sharing a row of the
+// given join table with the video
+func addOverlap(scores map[uint]float64, table, column string, videoID uint, weight float64) {
+	rows, err := db.Table(table+" a").
+		Select("b.video_id, count(*)").
+		Joins("JOIN "+table+" b ON a."+column+" = b."+column).
+		Where("a.video_id = ? AND b.video_id <> ?", videoID, videoID).
+		Group("b.video_id").Rows()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var count int
+		rows.Scan(&id, &count)
+		scores[id] += weight * float64(count)
+	}
+}
+
+// durationSimilarity returns 1 for equal durations down to 0 as they differ,
+// unknown durations are not similar to anything
+func durationSimilarity(a, b int) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return 1 - math.Abs(float64(a-b))/math.Max(float64(a), float64(b))
+}
+
+func excludeWatched(u User, candidates []relatedCandidate) []relatedCandidate {
+	var watched []uint
+	db.Model(&Watch{}).Where("user_id = ?", u.ID).Pluck("video_id", &watched)
+	seen := make(map[uint]bool, len(watched))
+	for _, id := range watched {
+		seen[id] = true
+	}
+
+	filtered := []relatedCandidate{}
+	for _, c := range candidates {
+		if !seen[c.ID] {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// recordWatch remembers the user has watched a video, rewatching only
+// refreshes the time it was last watched. Tokens without a user share ID 0
+// and are not tracked.
+func recordWatch(u User, videoID uint) {
+	if u.ID == 0 {
+		return
+	}
+	var watch Watch
+	db.Where(Watch{VideoID: videoID, UserID: u.ID}).FirstOrCreate(&watch)
+	db.Model(&watch).Update("updated_at", gorm.NowFunc())
+}
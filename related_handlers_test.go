@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetRelatedVideos(t *testing.T) {
+	Convey("Given videos sharing tags, actors and tubes", t, func() {
+		setupTestSuite()
+		tube := Tube{Name: "tube"}
+		db.Create(&tube)
+		tag := Tag{Name: "tag"}
+		db.Create(&tag)
+		actor := Actor{Name: "actor"}
+		db.Create(&actor)
+
+		v := Video{Title: "main", Duration: "10:00", TubeID: tube.ID, Tags: []Tag{tag}, Actors: []Actor{actor}}
+		db.Create(&v)
+		db.Create(&Video{Title: "tag", Tags: []Tag{tag}})
+		db.Create(&Video{Title: "actor and tag", Tags: []Tag{tag}, Actors: []Actor{actor}})
+		db.Create(&Video{Title: "tube", TubeID: tube.ID, Duration: "500"})
+		db.Create(&Video{Title: "unrelated"})
+		id := fmt.Sprint(v.ID)
+
+		Convey("When I call GET /videos/{id}/related", func() {
+			response := doRequest("GET", "/videos/"+id+"/related", nil)
+
+			Convey("Then I should get related videos by descending overlap", func() {
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 3)
+				So(gt.Videos[0].Title, ShouldEqual, "actor and tag")
+				So(gt.Videos[1].Title, ShouldEqual, "tag")
+				So(gt.Videos[2].Title, ShouldEqual, "tube")
+			})
+
+			Convey("And I should get a 200 response", func() {
+				So(response.Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When a user who watched a related video excludes watched ones", func() {
+			alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+			watched := Video{}
+			db.Where("title = ?", "actor and tag").First(&watched)
+			doRequestAs(alice, "GET", fmt.Sprintf("/videos/%d", watched.ID), nil)
+
+			response := doRequestAs(alice, "GET", "/videos/"+id+"/related?exclude_watched=true", nil)
+
+			Convey("Then the watched video should be left out", func() {
+				gt := GetVideos{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Videos), ShouldEqual, 2)
+				So(gt.Videos[0].Title, ShouldEqual, "tag")
+			})
+		})
+
+		Convey("When a token without a user watches a video", func() {
+			doRequest("GET", "/videos/"+id, nil)
+
+			Convey("Then the watch should not be recorded", func() {
+				var n int
+				db.Model(&Watch{}).Where("user_id = 0").Count(&n)
+				So(n, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestDurationSeconds(t *testing.T) {
+	Convey("Given durations in several notations", t, func() {
+		Convey("Then they should be converted to seconds", func() {
+			So(durationSeconds("754"), ShouldEqual, 754)
+			So(durationSeconds("12:34"), ShouldEqual, 754)
+			So(durationSeconds("1:02:03"), ShouldEqual, 3723)
+			So(durationSeconds("PT1H2M3S"), ShouldEqual, 3723)
+			So(durationSeconds("soon"), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,187 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// ReportOpen is the status of a report waiting for a moderator
+	ReportOpen = "open"
+	// ReportResolved is the status of a report a moderator acted upon
+	ReportResolved = "resolved"
+	// ReportDismissed is the status of a report a moderator turned down
+	ReportDismissed = "dismissed"
+
+	// HiddenReports is the reason given to videos hidden after being reported
+	HiddenReports = "reports"
+)
+
+// ReportReasons lists the reasons a video can be reported for
+var ReportReasons = []string{"broken", "mislabeled", "inappropriate", "duplicate", "other"}
+
+// ReportHideThreshold is the number of users with an open report on a video
+// that hides it until a moderator looks at it, 0 never hides videos
+var ReportHideThreshold = getEnvInt("REPORT_HIDE_THRESHOLD", 5)
+
+type Report struct {
+	gorm.Model
+	Uuid       string     `json:"uuid"`
+	VideoID    uint       `json:"video_id" gorm:"index"`
+	UserID     uint       `json:"user_id"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment"`
+	Status     string     `json:"status" gorm:"index"`
+	ResolvedBy uint       `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ReportedVideo groups the open reports of a video in the moderation queue
+type ReportedVideo struct {
+	Video   Video    `json:"video"`
+	Count   int      `json:"count"`
+	Reports []Report `json:"reports"`
+}
+
+type GetReports struct {
+	Nav    Navigation      `json:"nav"`
+	Videos []ReportedVideo `json:"videos"`
+}
+
+var ReportsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	page := getPage(r)
+	rows := []struct {
+		VideoID uint
+		Count   int
+	}{}
+	db.Model(&Report{}).Select("video_id, count(*) as count").Where("status = ?", ReportOpen).
+		Group("video_id").Order("count desc, video_id").Offset(page * limit).Limit(limit).Scan(&rows)
+
+	videos := []ReportedVideo{}
+	for _, row := range rows {
+		reported := ReportedVideo{Count: row.Count, Reports: []Report{}}
+		if db.First(&reported.Video, row.VideoID).RecordNotFound() {
+			continue
+		}
+		db.Where("video_id = ? AND status = ?", row.VideoID, ReportOpen).Order("id").Find(&reported.Reports)
+		videos = append(videos, reported)
+	}
+	nav := getNavigation(len(rows), page, limit)
+
+	writeJSON(w, GetReports{Nav: nav, Videos: videos})
+})
+
+var ReportsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Videos are hidden once enough distinct users reported them
+	user := currentUser(r)
+	if user.ID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t Report
+	mapReport(r, &t)
+	if !validReason(t.Reason) {
+		http.Error(w, "Unknown report reason", http.StatusBadRequest)
+		return
+	}
+
+	// A user has at most one open report per video
+	var report Report
+	db.Where("video_id = ? AND user_id = ? AND status = ?", video.ID, user.ID, ReportOpen).First(&report)
+	report.VideoID = video.ID
+	report.UserID = user.ID
+	report.Reason = t.Reason
+	report.Comment = t.Comment
+	report.Status = ReportOpen
+	db.Save(&report)
+	updateReportedVideo(video)
+
+	writeJSONStatus(w, http.StatusCreated, report)
+})
+
+var ReportResolveHandler = reportActionHandler(ReportResolved)
+
+var ReportDismissHandler = reportActionHandler(ReportDismissed)
+
+// reportActionHandler closes an open report with the given status
+func reportActionHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		if findByID(db, &report, mux.Vars(r)["id"]) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if report.Status != ReportOpen {
+			http.Error(w, "Report is already "+report.Status, http.StatusConflict)
+			return
+		}
+
+		now := time.Now()
+		report.Status = status
+		report.ResolvedBy = currentUser(r).ID
+		report.ResolvedAt = &now
+		db.Save(&report)
+
+		var video Video
+		if !db.First(&video, report.VideoID).RecordNotFound() {
+			updateReportedVideo(video)
+		}
+
+		writeJSON(w, report)
+	}
+}
+
+// updateReportedVideo hides a video once enough users reported it, and shows
+// it again when moderators closed enough of the reports
+func updateReportedVideo(video Video) {
+	if ReportHideThreshold <= 0 {
+		return
+	}
+	var users int
+	db.Model(&Report{}).Where("video_id = ? AND status = ?", video.ID, ReportOpen).
+		Select("count(distinct user_id)").Count(&users)
+
+	if users >= ReportHideThreshold && !video.Hidden {
+		db.Model(&video).UpdateColumns(map[string]interface{}{"hidden": true, "hidden_reason": HiddenReports})
+		updateTagStats(video.ID)
+	} else if users < ReportHideThreshold && video.Hidden && video.HiddenReason == HiddenReports {
+		db.Model(&video).UpdateColumns(map[string]interface{}{"hidden": false, "hidden_reason": ""})
+		updateTagStats(video.ID)
+	}
+}
+
+func validReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func mapReport(r *http.Request, t *Report) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Uuid = ""
+}
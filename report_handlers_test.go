@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReports(t *testing.T) {
+	defer func(threshold int) { ReportHideThreshold = threshold }(ReportHideThreshold)
+
+	Convey("Given a video and a threshold of two reports", t, func() {
+		setupTestSuite()
+		ReportHideThreshold = 2
+		moderator := User{Name: "moderator", Role: RoleModerator}
+		alice := User{Model: gorm.Model{ID: 1}, Name: "alice"}
+		bob := User{Model: gorm.Model{ID: 2}, Name: "bob"}
+		v := Video{Title: "test"}
+		db.Create(&v)
+		id := fmt.Sprint(v.ID)
+
+		Convey("When I report it with an unknown reason", func() {
+			response := doRequestAs(alice, "POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "boring"}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("When a token without a user reports it", func() {
+			response := doRequest("POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "broken"}`))
+
+			Convey("Then I should get a 401 response", func() {
+				So(response.Code, ShouldEqual, 401)
+			})
+		})
+
+		Convey("When the same user reports it twice", func() {
+			doRequestAs(alice, "POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "broken"}`))
+			response := doRequestAs(alice, "POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "broken", "comment": "no sound"}`))
+
+			Convey("Then only one report should be open", func() {
+				var n int
+				db.Model(&Report{}).Where("video_id = ?", v.ID).Count(&n)
+				So(response.Code, ShouldEqual, 201)
+				So(n, ShouldEqual, 1)
+			})
+
+			Convey("Then the video should stay visible", func() {
+				So(doRequest("GET", "/videos/"+id, nil).Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("When two users report it", func() {
+			doRequestAs(alice, "POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "broken"}`))
+			doRequestAs(bob, "POST", "/videos/"+id+"/reports", bytes.NewBufferString(`{"reason": "mislabeled"}`))
+
+			Convey("Then the video should be hidden", func() {
+				video := Video{}
+				db.First(&video, v.ID)
+				So(video.Hidden, ShouldBeTrue)
+				So(video.HiddenReason, ShouldEqual, HiddenReports)
+			})
+
+			Convey("Then moderators should see it in the queue", func() {
+				response := doRequestAs(moderator, "GET", "/reports", nil)
+				reports := GetReports{}
+				json.Unmarshal(response.Body.Bytes(), &reports)
+				So(len(reports.Videos), ShouldEqual, 1)
+				So(reports.Videos[0].Video.ID, ShouldEqual, v.ID)
+				So(reports.Videos[0].Count, ShouldEqual, 2)
+				So(len(reports.Videos[0].Reports), ShouldEqual, 2)
+			})
+
+			Convey("Then users should not see the queue", func() {
+				So(doRequestAs(alice, "GET", "/reports", nil).Code, ShouldEqual, 403)
+			})
+
+			Convey("When a moderator dismisses one of the reports", func() {
+				report := Report{}
+				db.Where("video_id = ?", v.ID).First(&report)
+				response := doRequestAs(moderator, "POST", "/reports/"+fmt.Sprint(report.ID)+"/dismiss", nil)
+
+				Convey("Then the report should be closed", func() {
+					db.First(&report, report.ID)
+					So(response.Code, ShouldEqual, 200)
+					So(report.Status, ShouldEqual, ReportDismissed)
+					So(report.ResolvedAt, ShouldNotBeNil)
+				})
+
+				Convey("Then the video should be visible again", func() {
+					video := Video{}
+					db.First(&video, v.ID)
+					So(video.Hidden, ShouldBeFalse)
+				})
+
+				Convey("Then dismissing it again should conflict", func() {
+					response := doRequestAs(moderator, "POST", "/reports/"+fmt.Sprint(report.ID)+"/resolve", nil)
+					So(response.Code, ShouldEqual, 409)
+				})
+			})
+		})
+	})
+}
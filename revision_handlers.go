@@ -0,0 +1,246 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// RevisionCreate records the creation of an item
+	RevisionCreate = "create"
+	// RevisionUpdate records a change of an item
+	RevisionUpdate = "update"
+	// RevisionDelete records the deletion of an item
+	RevisionDelete = "delete"
+	// RevisionRevert records an item going back to a previous revision
+	RevisionRevert = "revert"
+	// RevisionAutoTag records tags applied to a video by automatic tagging
+	RevisionAutoTag = "autotag"
+)
+
+// revisionFields lists the JSON fields kept in the history of each item type,
+// fields maintained by the server (ratings, link checks, moderation) are left
+// out so a revert cannot overwrite them
+var revisionFields = map[string][]string{
+	"videos": {"title", "url", "extid", "duration", "embed", "small_images",
+		"medium_images", "big_images", "master_image", "sexuality", "tube_id", "uploaded"},
+	"actors": {"name", "measurements", "height", "date_of_birth", "description", "social_links"},
+}
+
+// Revision records a change made to a video or an actor. Snapshot holds the
+// item after the change and Diff the fields that changed, as
+// {"field": {"from": old, "to": new}}.
+type Revision struct {
+	gorm.Model
+	Uuid     string   `json:"uuid"`
+	ItemType string   `json:"item_type" gorm:"index:idx_revision_item"`
+	ItemID   uint     `json:"item_id" gorm:"index:idx_revision_item"`
+	Action   string   `json:"action"`
+	UserID   uint     `json:"user_id"`
+	Diff     JSONText `json:"diff" gorm:"type:text"`
+	Snapshot JSONText `json:"snapshot" gorm:"type:text"`
+}
+
+// JSONText is JSON stored as text and written as is in responses
+type JSONText string
+
+func (t JSONText) MarshalJSON() ([]byte, error) {
+	if t == "" {
+		return []byte("null"), nil
+	}
+	return []byte(t), nil
+}
+
+func (t *JSONText) UnmarshalJSON(data []byte) error {
+	*t = JSONText(data)
+	return nil
+}
+
+type GetRevisions struct {
+	Nav       Navigation `json:"nav"`
+	Revisions []Revision `json:"revisions"`
+}
+
+// revisionsHandler lists the history of an item, newest first
+func revisionsHandler(itemType string, find func(r *http.Request) (uint, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := find(r)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		limit := 100
+		page := getPage(r)
+		revisions := []Revision{}
+		db.Where("item_type = ? AND item_id = ?", itemType, id).Order("id desc").
+			Offset(page * limit).Limit(limit).Find(&revisions)
+		nav := getNavigation(len(revisions), page, limit)
+
+		writeJSON(w, GetRevisions{Nav: nav, Revisions: revisions})
+	}
+}
+
+var VideoRevisionsGetHandler = revisionsHandler("videos", func(r *http.Request) (uint, error) {
+	var video Video
+	err := getVideo(r, &video)
+	return video.ID, err
+})
+
+var ActorRevisionsGetHandler = revisionsHandler("actors", func(r *http.Request) (uint, error) {
+	var actor Actor
+	err := getActor(r, &actor)
+	return actor.ID, err
+})
+
+var VideoRevertHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	before := video
+	if !revert(r, "videos", video.ID, &video) {
+		http.NotFound(w, r)
+		return
+	}
+	sanitizeVideoEmbed(&video)
+	db.Save(&video)
+	recordRevision(currentUser(r).ID, RevisionRevert, "videos", video.ID, before, video)
+	updateTagStats(video.ID)
+
+	writeJSON(w, video)
+})
+
+var ActorRevertHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var actor Actor
+	if getActor(r, &actor) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Model(&actor).Related(&actor.SocialLinks)
+	before := actor
+	// Revisions recorded before social links were tracked keep the current
+	// ones
+	actor.SocialLinks = nil
+	if !revert(r, "actors", actor.ID, &actor) {
+		http.NotFound(w, r)
+		return
+	}
+	if actor.SocialLinks == nil {
+		actor.SocialLinks = before.SocialLinks
+	} else {
+		db.Unscoped().Where("actor_id = ?", actor.ID).Delete(SocialLink{})
+		for i := range actor.SocialLinks {
+			actor.SocialLinks[i].Model = gorm.Model{}
+			actor.SocialLinks[i].ActorID = actor.ID
+		}
+	}
+	db.Save(&actor)
+	recordRevision(currentUser(r).ID, RevisionRevert, "actors", actor.ID, before, actor)
+
+	writeJSON(w, actor)
+})
+
+// revert applies the snapshot of the revision named in the route to item,
+// telling whether the revision was found
+func revert(r *http.Request, itemType string, id uint, item interface{}) bool {
+	var revision Revision
+	query := db.Where("item_type = ? AND item_id = ?", itemType, id)
+	if findByID(query, &revision, mux.Vars(r)["revision"]) != nil || revision.Snapshot == "" {
+		return false
+	}
+	return json.Unmarshal([]byte(revision.Snapshot), item) == nil
+}
+
+// recordRevision stores a change of an item made by the given user, 0 being
+// the server itself. before is nil for a creation and after is nil for a
+// deletion. Updates that do not change any tracked field are not recorded.
+func recordRevision(userID uint, action, itemType string, id uint, before, after interface{}) {
+	from := snapshot(itemType, before)
+	to := snapshot(itemType, after)
+
+	diff := map[string]map[string]interface{}{}
+	for _, field := range revisionFields[itemType] {
+		if !reflect.DeepEqual(from[field], to[field]) {
+			diff[field] = map[string]interface{}{"from": from[field], "to": to[field]}
+		}
+	}
+	if len(diff) == 0 && action == RevisionUpdate {
+		return
+	}
+
+	revision := Revision{
+		ItemType: itemType,
+		ItemID:   id,
+		Action:   action,
+		UserID:   userID,
+		Diff:     toJSONText(diff),
+	}
+	if after != nil {
+		revision.Snapshot = toJSONText(to)
+	}
+	db.Create(&revision)
+}
+
+// recordTagRevision stores a change of the tags of a video made by the
+// server. Tags are not among the tracked fields, the diff names them and the
+// snapshot is the video as it is.
+func recordTagRevision(action string, video Video, before, after []Tag) {
+	names := func(tags []Tag) []string {
+		list := []string{}
+		for _, tag := range tags {
+			list = append(list, tag.Name)
+		}
+		return list
+	}
+	diff := map[string]map[string]interface{}{
+		"tags": {"from": names(before), "to": names(after)},
+	}
+	db.Create(&Revision{
+		ItemType: "videos",
+		ItemID:   video.ID,
+		Action:   action,
+		Diff:     toJSONText(diff),
+		Snapshot: toJSONText(snapshot("videos", video)),
+	})
+}
+
+// snapshot returns the tracked fields of an item
+func snapshot(itemType string, item interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if item == nil {
+		return fields
+	}
+	all := map[string]interface{}{}
+	data, _ := json.Marshal(item)
+	json.Unmarshal(data, &all)
+	for _, field := range revisionFields[itemType] {
+		fields[field] = all[field]
+	}
+	// Social links are replaced on every change, only their site and URL
+	// tell them apart
+	if links, ok := fields["social_links"].([]interface{}); ok {
+		for i, link := range links {
+			if l, ok := link.(map[string]interface{}); ok {
+				links[i] = map[string]interface{}{"type": l["type"], "url": l["url"]}
+			}
+		}
+	}
+	return fields
+}
+
+func toJSONText(v interface{}) JSONText {
+	data, _ := json.Marshal(v)
+	return JSONText(data)
+}
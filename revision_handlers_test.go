@@ -0,0 +1,153 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVideoRevisions(t *testing.T) {
+	Convey("Given an editor created and renamed a video", t, func() {
+		setupTestSuite()
+		editor := User{Model: gorm.Model{ID: 7}, Name: "editor", Role: RoleEditor}
+		response := doRequestAs(editor, "POST", "/videos", bytes.NewBufferString(`{"title": "first"}`))
+		video := Video{}
+		json.Unmarshal(response.Body.Bytes(), &video)
+		id := fmt.Sprint(video.ID)
+		doRequestAs(editor, "PATCH", "/videos/"+id, bytes.NewBufferString(`{"title": "second"}`))
+
+		Convey("When I call GET /videos/{id}/revisions", func() {
+			response := doRequestAs(editor, "GET", "/videos/"+id+"/revisions", nil)
+			revisions := GetRevisions{}
+			json.Unmarshal(response.Body.Bytes(), &revisions)
+
+			Convey("Then both changes should be listed, newest first", func() {
+				So(len(revisions.Revisions), ShouldEqual, 2)
+				So(revisions.Revisions[0].Action, ShouldEqual, RevisionUpdate)
+				So(revisions.Revisions[1].Action, ShouldEqual, RevisionCreate)
+			})
+
+			Convey("Then the update should record its author and diff", func() {
+				diff := map[string]map[string]interface{}{}
+				json.Unmarshal([]byte(revisions.Revisions[0].Diff), &diff)
+				So(revisions.Revisions[0].UserID, ShouldEqual, 7)
+				So(len(diff), ShouldEqual, 1)
+				So(diff["title"]["from"], ShouldEqual, "first")
+				So(diff["title"]["to"], ShouldEqual, "second")
+			})
+		})
+
+		Convey("When the video is saved without changes", func() {
+			doRequestAs(editor, "PATCH", "/videos/"+id, bytes.NewBufferString(`{"title": "second"}`))
+
+			Convey("Then no revision should be added", func() {
+				var n int
+				db.Model(&Revision{}).Where("item_type = ? AND item_id = ?", "videos", video.ID).Count(&n)
+				So(n, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When an editor reverts to the first revision", func() {
+			first := Revision{}
+			db.Where("item_type = ? AND item_id = ?", "videos", video.ID).Order("id").First(&first)
+			response := doRequestAs(editor, "POST", "/videos/"+id+"/revisions/"+fmt.Sprint(first.ID)+"/revert", nil)
+
+			Convey("Then the video should get its first title back", func() {
+				v := Video{}
+				db.First(&v, video.ID)
+				So(response.Code, ShouldEqual, 200)
+				So(v.Title, ShouldEqual, "first")
+			})
+
+			Convey("Then the revert should be recorded", func() {
+				last := Revision{}
+				db.Where("item_type = ? AND item_id = ?", "videos", video.ID).Order("id desc").First(&last)
+				So(last.Action, ShouldEqual, RevisionRevert)
+			})
+		})
+
+		Convey("When a user without a role tries to revert", func() {
+			response := doRequest("POST", "/videos/"+id+"/revisions/1/revert", nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When the video is deleted", func() {
+			doRequestAs(editor, "DELETE", "/videos/"+id, nil)
+
+			Convey("Then the deletion should be recorded", func() {
+				last := Revision{}
+				db.Where("item_type = ? AND item_id = ?", "videos", video.ID).Order("id desc").First(&last)
+				So(last.Action, ShouldEqual, RevisionDelete)
+				So(last.Snapshot, ShouldEqual, "")
+			})
+		})
+	})
+}
+
+func TestActorRevisions(t *testing.T) {
+	Convey("Given an actor was renamed", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		response := doRequestAs(editor, "POST", "/actors", bytes.NewBufferString(`{"name": "before"}`))
+		actor := Actor{}
+		json.Unmarshal(response.Body.Bytes(), &actor)
+		id := fmt.Sprint(actor.ID)
+		doRequestAs(editor, "PATCH", "/actors/"+id, bytes.NewBufferString(`{"name": "after"}`))
+
+		Convey("When an editor reverts to the creation", func() {
+			first := Revision{}
+			db.Where("item_type = ? AND item_id = ?", "actors", actor.ID).Order("id").First(&first)
+			response := doRequestAs(editor, "POST", "/actors/"+id+"/revisions/"+fmt.Sprint(first.ID)+"/revert", nil)
+
+			Convey("Then the actor should get its name back", func() {
+				a := Actor{}
+				db.First(&a, actor.ID)
+				So(response.Code, ShouldEqual, 200)
+				So(a.Name, ShouldEqual, "before")
+			})
+		})
+
+		Convey("When its social links are changed and reverted", func() {
+			doRequestAs(editor, "PATCH", "/actors/"+id,
+				bytes.NewBufferString(`{"name": "after", "social_links": [{"type": "twitter", "url": "https://twitter.com/a"}]}`))
+			doRequestAs(editor, "PATCH", "/actors/"+id,
+				bytes.NewBufferString(`{"name": "after", "social_links": [{"type": "twitter", "url": "https://twitter.com/b"}]}`))
+			revisions := []Revision{}
+			db.Where("item_type = ? AND item_id = ?", "actors", actor.ID).Order("id").Find(&revisions)
+			response := doRequestAs(editor, "POST", "/actors/"+id+"/revisions/"+fmt.Sprint(revisions[2].ID)+"/revert", nil)
+
+			Convey("Then every change of the links should be recorded", func() {
+				So(len(revisions), ShouldEqual, 4)
+				So(string(revisions[3].Diff), ShouldContainSubstring, "social_links")
+				So(string(revisions[3].Diff), ShouldContainSubstring, "https://twitter.com/b")
+			})
+
+			Convey("Then the links of the revision should be back", func() {
+				So(response.Code, ShouldEqual, 200)
+				links := []SocialLink{}
+				db.Where("actor_id = ?", actor.ID).Find(&links)
+				So(len(links), ShouldEqual, 1)
+				So(links[0].URL, ShouldEqual, "https://twitter.com/a")
+			})
+		})
+
+		Convey("When I revert to a revision of another item", func() {
+			response := doRequestAs(editor, "POST", "/actors/"+id+"/revisions/0/revert", nil)
+
+			Convey("Then I should get a 404 response", func() {
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+	})
+}
@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// VideoSearch is the body of a video search. Videos must have every tag, or a
+// tag below it, and every actor, tags and actors being given by ID, UUID or
+// name.
+type VideoSearch struct {
+	Tags   []string `json:"tags"`
+	Actors []string `json:"actors"`
+	Title  string   `json:"title"`
+	Sort   string   `json:"sort"`
+}
+
+var VideoSearchesPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var t VideoSearch
+	mapVideoSearch(r, &t)
+	if t.Sort == "" {
+		t.Sort = "newest"
+	}
+	order, ok := filmographySorts[t.Sort]
+	if !ok {
+		http.Error(w, "Unknown sort "+t.Sort, http.StatusBadRequest)
+		return
+	}
+
+	query, err := searchVideos(db.Scopes(visibleVideos(r)), t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	limit := 100
+	page := getPage(r)
+	videos := []Video{}
+	query.Order(order).Offset(page * limit).Limit(limit).Find(&videos)
+	setUserRatings(currentUser(r), videos)
+	nav := getNavigation(len(videos), page, limit)
+
+	writeJSON(w, GetVideos{Nav: nav, Videos: videos})
+})
+
+// searchVideos restricts a video query to the videos matching a search
+func searchVideos(query *gorm.DB, t VideoSearch) (*gorm.DB, error) {
+	for _, name := range t.Tags {
+		tag, err := findTag(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown tag %s", name)
+		}
+		query = withTags(query, tag)
+	}
+	for _, name := range t.Actors {
+		actor, err := findActor(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown actor %s", name)
+		}
+		var ids []uint
+		db.Table("video_actors").Where("actor_id = ?", actor.ID).Pluck("video_id", &ids)
+		query = query.Where("videos.id IN (?)", ids)
+	}
+	if title := strings.TrimSpace(t.Title); title != "" {
+		query = query.Where("lower(videos.title) LIKE ?", "%"+strings.ToLower(title)+"%")
+	}
+	return query, nil
+}
+
+// IsEmpty tells whether the search has no criteria, matching every video
+func (t VideoSearch) IsEmpty() bool {
+	return len(t.Tags) == 0 && len(t.Actors) == 0 && strings.TrimSpace(t.Title) == ""
+}
+
+func mapVideoSearch(r *http.Request, t *VideoSearch) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
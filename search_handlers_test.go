@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVideoSearches(t *testing.T) {
+	Convey("Given videos tagged with a parent tag and its child", t, func() {
+		setupTestSuite()
+		hair := Tag{Name: "Hair"}
+		db.Create(&hair)
+		blonde := Tag{Name: "Blonde", ParentID: &hair.ID}
+		db.Create(&blonde)
+		db.Create(&TagSynonym{TagID: blonde.ID, Name: "Blondes", Slug: "blondes"})
+		db.Create(&Video{Title: "Parent", Tags: []Tag{hair}})
+		db.Create(&Video{Title: "Child", Tags: []Tag{blonde}, Actors: []Actor{{Name: "Jane Doe"}}})
+		db.Create(&Video{Title: "Untagged"})
+
+		Convey("When I search the parent tag", func() {
+			response := doRequest("POST", "/videos/searches", bytes.NewBufferString(`{"tags": ["hair"]}`))
+			videos := GetVideos{}
+			json.Unmarshal(response.Body.Bytes(), &videos)
+
+			Convey("Then the videos of the child tag should be included", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(len(videos.Videos), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I search a synonym of the child tag with an actor", func() {
+			response := doRequest("POST", "/videos/searches",
+				bytes.NewBufferString(`{"tags": ["blondes"], "actors": ["jane doe"], "sort": "title"}`))
+			videos := GetVideos{}
+			json.Unmarshal(response.Body.Bytes(), &videos)
+
+			Convey("Then only the child video should be found", func() {
+				So(len(videos.Videos), ShouldEqual, 1)
+				So(videos.Videos[0].Title, ShouldEqual, "Child")
+			})
+		})
+
+		Convey("When I filter the video list by the parent tag", func() {
+			response := doRequest("GET", "/videos?tag=hair", nil)
+			videos := GetVideos{}
+			json.Unmarshal(response.Body.Bytes(), &videos)
+
+			Convey("Then the videos of the child tag should be included", func() {
+				So(len(videos.Videos), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I search an unknown tag", func() {
+			response := doRequest("POST", "/videos/searches", bytes.NewBufferString(`{"tags": ["nope"]}`))
+
+			Convey("Then I should get a 422 response", func() {
+				So(response.Code, ShouldEqual, 422)
+			})
+		})
+	})
+}
@@ -5,13 +5,15 @@
 package main
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 )
 
 var db *gorm.DB
 
-func setupRouter() *mux.Router {
+func setupRouter() http.Handler {
 	r := mux.NewRouter()
 
 	// Status
@@ -20,9 +22,18 @@ func setupRouter() *mux.Router {
 	// Tags
 	r.Handle("/tags", jwtMiddleware.Handler(TagsGetHandler)).Methods("GET")
 	r.Handle("/tags", jwtMiddleware.Handler(TagsPostHandler)).Methods("POST")
+	r.Handle("/tags/popular", jwtMiddleware.Handler(PopularTagsGetHandler)).Methods("GET")
+	r.Handle("/tags/stats/rebuild", jwtMiddleware.Handler(requireRole(TagStatsRebuildHandler, RoleAdmin))).Methods("POST")
+	r.Handle("/tags/suggestions", jwtMiddleware.Handler(TagSuggestionsPostHandler)).Methods("POST")
 	r.Handle("/tags/{id}", jwtMiddleware.Handler(TagsPatchHandler)).Methods("PATCH")
 	r.Handle("/tags/{id}", jwtMiddleware.Handler(TagGetHandler)).Methods("GET")
 	r.Handle("/tags/{id}", jwtMiddleware.Handler(TagDeleteHandler)).Methods("DELETE")
+	r.Handle("/tags/{id}/related", jwtMiddleware.Handler(RelatedTagsGetHandler)).Methods("GET")
+	r.Handle("/tags/{id}/synonyms", jwtMiddleware.Handler(TagSynonymsPostHandler)).Methods("POST")
+	r.Handle("/tags/{id}/synonyms/{synonym}", jwtMiddleware.Handler(TagSynonymDeleteHandler)).Methods("DELETE")
+	r.Handle("/tags/{id}/merge", jwtMiddleware.Handler(requireRole(TagMergeHandler, RoleEditor))).Methods("POST")
+	r.Handle("/tags/{id}/retag", jwtMiddleware.Handler(requireRole(TagRetagHandler, RoleEditor))).Methods("POST")
+	r.Handle("/tags/{id}/restore", jwtMiddleware.Handler(requireRole(restoreHandler("tags"), RoleEditor))).Methods("POST")
 
 	// Actors
 	r.Handle("/actors", jwtMiddleware.Handler(ActorsGetHandler)).Methods("GET")
@@ -30,14 +41,51 @@ func setupRouter() *mux.Router {
 	r.Handle("/actors/{id}", jwtMiddleware.Handler(ActorsPatchHandler)).Methods("PATCH")
 	r.Handle("/actors/{id}", jwtMiddleware.Handler(ActorGetHandler)).Methods("GET")
 	r.Handle("/actors/{id}", jwtMiddleware.Handler(ActorDeleteHandler)).Methods("DELETE")
+	r.Handle("/actors/{id}/aliases", jwtMiddleware.Handler(ActorAliasesPostHandler)).Methods("POST")
+	r.Handle("/actors/{id}/aliases/{alias}", jwtMiddleware.Handler(ActorAliasDeleteHandler)).Methods("DELETE")
+	r.Handle("/actors/{id}/photos", jwtMiddleware.Handler(PhotosGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/photos", jwtMiddleware.Handler(PhotosPostHandler)).Methods("POST")
+	r.Handle("/actors/{id}/photos/{photo}", jwtMiddleware.Handler(PhotoPatchHandler)).Methods("PATCH")
+	r.Handle("/actors/{id}/photos/{photo}", jwtMiddleware.Handler(PhotoDeleteHandler)).Methods("DELETE")
+	r.Handle("/actors/{id}/merge", jwtMiddleware.Handler(requireRole(ActorMergeHandler, RoleEditor))).Methods("POST")
+	r.Handle("/actors/{id}/videos", jwtMiddleware.Handler(ActorVideosGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/stats", jwtMiddleware.Handler(ActorStatsGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/costars", jwtMiddleware.Handler(CostarsGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/path/{other}", jwtMiddleware.Handler(ActorPathGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/revisions", jwtMiddleware.Handler(ActorRevisionsGetHandler)).Methods("GET")
+	r.Handle("/actors/{id}/revisions/{revision}/revert", jwtMiddleware.Handler(requireRole(ActorRevertHandler, RoleEditor))).Methods("POST")
+	r.Handle("/actors/{id}/restore", jwtMiddleware.Handler(requireRole(restoreHandler("actors"), RoleEditor))).Methods("POST")
 
 	// Videos
 	r.Handle("/videos", jwtMiddleware.Handler(VideosGetHandler)).Methods("GET")
 	r.Handle("/videos", jwtMiddleware.Handler(VideosPostHandler)).Methods("POST")
+	r.Handle("/videos/imports", jwtMiddleware.Handler(VideoImportsPostHandler)).Methods("POST")
+	r.Handle("/videos/links", jwtMiddleware.Handler(requireRole(VideoLinksGetHandler, RoleEditor))).Methods("GET")
+	r.Handle("/videos/trending", jwtMiddleware.Handler(TrendingVideosGetHandler)).Methods("GET")
+	r.Handle("/videos/popular", jwtMiddleware.Handler(PopularVideosGetHandler)).Methods("GET")
 	r.Handle("/videos/{id}", jwtMiddleware.Handler(VideosPatchHandler)).Methods("PATCH")
 	r.Handle("/videos/{id}", jwtMiddleware.Handler(VideoGetHandler)).Methods("GET")
 	r.Handle("/videos/{id}", jwtMiddleware.Handler(VideoDeleteHandler)).Methods("DELETE")
-	r.Handle("/videos/searches", jwtMiddleware.Handler(NotImplemented)).Methods("POST")
+	r.Handle("/videos/searches", jwtMiddleware.Handler(VideoSearchesPostHandler)).Methods("POST")
+	r.Handle("/videos/{id}/revisions", jwtMiddleware.Handler(VideoRevisionsGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/revisions/{revision}/revert", jwtMiddleware.Handler(requireRole(VideoRevertHandler, RoleEditor))).Methods("POST")
+	r.Handle("/videos/{id}/restore", jwtMiddleware.Handler(requireRole(restoreHandler("videos"), RoleEditor))).Methods("POST")
+	r.Handle("/videos/{id}/submit", jwtMiddleware.Handler(VideoSubmitHandler)).Methods("POST")
+	r.Handle("/videos/{id}/approve", jwtMiddleware.Handler(requireRole(VideoApproveHandler, RoleEditor))).Methods("POST")
+	r.Handle("/videos/{id}/reject", jwtMiddleware.Handler(requireRole(VideoRejectHandler, RoleEditor))).Methods("POST")
+	r.Handle("/videos/{id}/archive", jwtMiddleware.Handler(requireRole(VideoArchiveHandler, RoleEditor))).Methods("POST")
+	r.Handle("/videos/{id}/tag-suggestions", jwtMiddleware.Handler(VideoTagSuggestionsGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/markers", jwtMiddleware.Handler(MarkersGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/markers", jwtMiddleware.Handler(MarkersPostHandler)).Methods("POST")
+	r.Handle("/videos/{id}/markers/{marker}", jwtMiddleware.Handler(MarkerPatchHandler)).Methods("PATCH")
+	r.Handle("/videos/{id}/markers/{marker}", jwtMiddleware.Handler(MarkerDeleteHandler)).Methods("DELETE")
+	r.Handle("/videos/{id}/comments", jwtMiddleware.Handler(CommentsGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/comments", jwtMiddleware.Handler(CommentsPostHandler)).Methods("POST")
+	r.Handle("/videos/{id}/reports", jwtMiddleware.Handler(ReportsPostHandler)).Methods("POST")
+	r.Handle("/videos/{id}/related", jwtMiddleware.Handler(RelatedVideosGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/ratings", jwtMiddleware.Handler(RatingGetHandler)).Methods("GET")
+	r.Handle("/videos/{id}/ratings", jwtMiddleware.Handler(RatingsPostHandler)).Methods("POST")
+	r.Handle("/videos/{id}/ratings", jwtMiddleware.Handler(RatingDeleteHandler)).Methods("DELETE")
 
 	// Tubes
 	r.Handle("/tubes", jwtMiddleware.Handler(TubesGetHandler)).Methods("GET")
@@ -45,6 +93,9 @@ func setupRouter() *mux.Router {
 	r.Handle("/tubes/{id}", jwtMiddleware.Handler(TubesPatchHandler)).Methods("PATCH")
 	r.Handle("/tubes/{id}", jwtMiddleware.Handler(TubeGetHandler)).Methods("GET")
 	r.Handle("/tubes/{id}", jwtMiddleware.Handler(TubeDeleteHandler)).Methods("DELETE")
+	r.Handle("/tubes/{id}/restore", jwtMiddleware.Handler(requireRole(restoreHandler("tubes"), RoleEditor))).Methods("POST")
+	r.Handle("/tubes/{id}/syncs", jwtMiddleware.Handler(TubeSyncsGetHandler)).Methods("GET")
+	r.Handle("/tubes/{id}/syncs", jwtMiddleware.Handler(requireRole(TubeSyncsPostHandler, RoleEditor))).Methods("POST")
 
 	// Users
 	r.Handle("/users", jwtMiddleware.Handler(UsersGetHandler)).Methods("GET")
@@ -52,11 +103,45 @@ func setupRouter() *mux.Router {
 	r.Handle("/users/{id}", jwtMiddleware.Handler(UsersPatchHandler)).Methods("PATCH")
 	r.Handle("/users/{id}", jwtMiddleware.Handler(UserGetHandler)).Methods("GET")
 	r.Handle("/users/{id}", jwtMiddleware.Handler(UserDeleteHandler)).Methods("DELETE")
+	r.Handle("/users/{id}/restore", jwtMiddleware.Handler(requireRole(restoreHandler("users"), RoleAdmin))).Methods("POST")
+
+	// Markers
+	r.Handle("/markers", jwtMiddleware.Handler(MarkersSearchHandler)).Methods("GET")
+
+	// Comments
+	r.Handle("/comments/{id}", jwtMiddleware.Handler(CommentPatchHandler)).Methods("PATCH")
+	r.Handle("/comments/{id}", jwtMiddleware.Handler(CommentDeleteHandler)).Methods("DELETE")
+
+	// Reports
+	r.Handle("/reports", jwtMiddleware.Handler(requireRole(ReportsGetHandler, RoleModerator, RoleEditor))).Methods("GET")
+	r.Handle("/reports/{id}/resolve", jwtMiddleware.Handler(requireRole(ReportResolveHandler, RoleModerator, RoleEditor))).Methods("POST")
+	r.Handle("/reports/{id}/dismiss", jwtMiddleware.Handler(requireRole(ReportDismissHandler, RoleModerator, RoleEditor))).Methods("POST")
+
+	// Trash
+	r.Handle("/trash/{resource}", jwtMiddleware.Handler(requireRole(TrashGetHandler, RoleEditor))).Methods("GET")
+	r.Handle("/trash/{resource}/{id}", jwtMiddleware.Handler(requireRole(TrashDeleteHandler, RoleAdmin))).Methods("DELETE")
+
+	// Compliance records are restricted to admins
+	r.Handle("/compliance/actors/{id}", jwtMiddleware.Handler(requireRole(ActorComplianceGetHandler, RoleAdmin))).Methods("GET")
+	r.Handle("/compliance/actors/{id}", jwtMiddleware.Handler(requireRole(ActorCompliancePatchHandler, RoleAdmin))).Methods("PATCH")
+	r.Handle("/compliance/actors/{id}/documents", jwtMiddleware.Handler(requireRole(ComplianceDocumentsPostHandler, RoleAdmin))).Methods("POST")
+	r.Handle("/compliance/videos/{id}", jwtMiddleware.Handler(requireRole(VideoComplianceGetHandler, RoleAdmin))).Methods("GET")
+	r.Handle("/compliance/videos/{id}", jwtMiddleware.Handler(requireRole(VideoCompliancePatchHandler, RoleAdmin))).Methods("PATCH")
+	r.Handle("/compliance/report", jwtMiddleware.Handler(requireRole(ComplianceReportHandler, RoleAdmin))).Methods("GET")
+	r.Handle("/compliance/audit", jwtMiddleware.Handler(requireRole(ComplianceAuditGetHandler, RoleAdmin))).Methods("GET")
 
 	// Auth
 	r.Handle("/auth", GetTokenHandler).Methods("POST")
 
-	return r
+	// oEmbed is consumed by third party sites so it does not require a token
+	r.Handle("/oembed", OEmbedGetHandler).Methods("GET")
+
+	// Uploaded files are linked from public pages so they do not require a token
+	if storage, ok := PhotoStorage.(*LocalStorage); ok {
+		r.PathPrefix("/files/").Handler(http.StripPrefix("/files/", storage.Handler())).Methods("GET")
+	}
+
+	return withActorVersions(r)
 }
 
 func setupDB(connector string, database string) {
@@ -66,7 +151,44 @@ func setupDB(connector string, database string) {
 	}
 	db.AutoMigrate(&Tube{})
 	db.AutoMigrate(&Tag{})
+	db.AutoMigrate(&TagSynonym{})
+	db.AutoMigrate(&TagStat{})
+	db.AutoMigrate(&TagDailyCount{})
+	db.AutoMigrate(&TagPair{})
+	db.AutoMigrate(&CountedVideoTag{})
 	db.AutoMigrate(&Actor{})
 	db.AutoMigrate(&Video{})
 	db.AutoMigrate(&User{})
+	db.AutoMigrate(&Rating{})
+	db.AutoMigrate(&VideoRanking{})
+	db.AutoMigrate(&Watch{})
+	db.AutoMigrate(&TubeSync{})
+	db.AutoMigrate(&Revision{})
+	db.AutoMigrate(&Report{})
+	db.AutoMigrate(&Comment{})
+	db.AutoMigrate(&Marker{})
+	db.AutoMigrate(&ActorAlias{})
+	db.AutoMigrate(&ActorRedirect{})
+	db.AutoMigrate(&SocialLink{})
+	db.AutoMigrate(&Photo{})
+	db.AutoMigrate(&ComplianceRecord{})
+	db.AutoMigrate(&ComplianceDocument{})
+	db.AutoMigrate(&ProductionRecord{})
+	db.AutoMigrate(&ComplianceAudit{})
+	// The tag counts, rankings and actor redirects are derived from other
+	// records and never addressed on their own, they have no UUID
+	setupUUIDs(&Tube{}, &Tag{}, &Actor{}, &Video{}, &User{}, &Comment{}, &Marker{}, &Photo{},
+		&Report{}, &ActorAlias{}, &TagSynonym{}, &Revision{}, &Rating{}, &Watch{}, &TubeSync{},
+		&SocialLink{}, &ComplianceRecord{}, &ComplianceDocument{}, &ProductionRecord{}, &ComplianceAudit{})
+
+	migrateActorProfiles()
+	migrateTagSlugs()
+	migrateTagStats()
+
+	// Video.Rating used to be an integer, it now holds the average rating.
+	// AutoMigrate never changes the type of existing columns, and sqlite
+	// stores the averages as they are in an integer column.
+	if connector == "postgres" {
+		db.Exec("ALTER TABLE videos ALTER COLUMN rating TYPE double precision")
+	}
 }
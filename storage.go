@@ -0,0 +1,108 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps uploaded files under slash separated keys and tells where
+// clients can download them
+type Storage interface {
+	Save(key string, data io.Reader) error
+	Delete(key string) error
+	URL(key string) string
+}
+
+// PhotoStorage is where actor photos are kept
+var PhotoStorage Storage = NewLocalStorage()
+
+var errInvalidKey = errors.New("invalid storage key")
+
+// LocalStorage keeps files in a directory of the local disk and serves them
+// itself under BaseURL
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStorage returns a local storage configured from the environment
+func NewLocalStorage() *LocalStorage {
+	s := &LocalStorage{Dir: os.Getenv("STORAGE_DIR"), BaseURL: os.Getenv("STORAGE_URL")}
+	if s.Dir == "" {
+		s.Dir = "uploads"
+	}
+	if s.BaseURL == "" {
+		s.BaseURL = strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + "/files/"
+	}
+	return s
+}
+
+func (s *LocalStorage) Save(key string, data io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.BaseURL + key
+}
+
+// Handler serves the stored files, to be mounted under BaseURL. Directories
+// are not listed, which would give away every stored key.
+func (s *LocalStorage) Handler() http.Handler {
+	files := http.FileServer(http.Dir(s.Dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, err := s.path(r.URL.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// path returns the file of a key, refusing keys that leave the directory
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if key == "" || clean != "/"+key {
+		return "", errInvalidKey
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,251 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// TitleMatchConfidence is the confidence of a tag whose name is in the
+	// title of a video
+	TitleMatchConfidence = 0.9
+	// SynonymMatchConfidence is the confidence of a tag one of whose synonyms
+	// is in the title of a video
+	SynonymMatchConfidence = 0.8
+	// CooccurrenceWeight scales the share of the videos of a tag also having
+	// the suggested tag
+	CooccurrenceWeight = 0.7
+	// TubeWeight scales the share of the videos of the tube having the
+	// suggested tag
+	TubeWeight = 0.5
+	// SuggestionMinVideos is the number of videos a tag or a tube needs for
+	// its statistics to be trusted
+	SuggestionMinVideos = 5
+	// SuggestionMinConfidence is the confidence below which tags are not
+	// suggested
+	SuggestionMinConfidence = 0.1
+	// SuggestionLimit is the number of tags suggested at most
+	SuggestionLimit = 20
+)
+
+// AutoTagConfidence is the confidence, in percent, above which suggested tags
+// are applied to imported videos. 0 disables automatic tagging.
+var AutoTagConfidence = getEnvInt("AUTOTAG_MIN_CONFIDENCE", 0)
+
+// TagSuggestion is a tag proposed for a video with the confidence, between 0
+// and 1, that it applies and the reasons it was proposed for
+type TagSuggestion struct {
+	Tag        Tag      `json:"tag"`
+	Confidence float64  `json:"confidence"`
+	Reasons    []string `json:"reasons"`
+}
+
+// TagSuggestionRequest describes a video which has not been created yet
+type TagSuggestionRequest struct {
+	Title  string   `json:"title"`
+	TubeID uint     `json:"tube_id"`
+	Tags   []string `json:"tags"`
+}
+
+type GetTagSuggestions struct {
+	Suggestions []TagSuggestion `json:"suggestions"`
+}
+
+var VideoTagSuggestionsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var video Video
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	tags := []Tag{}
+	db.Model(&video).Related(&tags, "Tags")
+
+	writeJSON(w, GetTagSuggestions{Suggestions: suggestTags(video.Title, video.TubeID, tags)})
+})
+
+var TagSuggestionsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var t TagSuggestionRequest
+	mapTagSuggestionRequest(r, &t)
+	tags := []Tag{}
+	for _, name := range t.Tags {
+		tag, err := findTag(name)
+		if err != nil {
+			http.Error(w, "Unknown tag "+name, http.StatusUnprocessableEntity)
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	writeJSON(w, GetTagSuggestions{Suggestions: suggestTags(t.Title, t.TubeID, tags)})
+})
+
+// suggestTags proposes tags for a video from the tag names and synonyms found
+// in its title, the tags found along with its tags and the tags of its tube.
+// Each piece of evidence raises the confidence of a tag as independent
+// chances would.
+func suggestTags(title string, tubeID uint, existing []Tag) []TagSuggestion {
+	has := map[uint]bool{}
+	for _, tag := range existing {
+		has[tag.ID] = true
+	}
+	suggestions := map[uint]*TagSuggestion{}
+	suggest := func(id uint, confidence float64, reason string) {
+		if has[id] || confidence <= 0 {
+			return
+		}
+		s, ok := suggestions[id]
+		if !ok {
+			s = &TagSuggestion{Reasons: []string{}}
+			suggestions[id] = s
+		}
+		s.Confidence = 1 - (1-s.Confidence)*(1-confidence)
+		s.Reasons = append(s.Reasons, reason)
+	}
+
+	// Tag names and synonyms of up to three words in the title
+	if phrases := titlePhrases(title); len(phrases) > 0 {
+		var ids []uint
+		db.Model(&Tag{}).Where("slug IN (?)", phrases).Pluck("id", &ids)
+		for _, id := range ids {
+			suggest(id, TitleMatchConfidence, "title")
+		}
+		synonyms := []TagSynonym{}
+		db.Where("slug IN (?)", phrases).Find(&synonyms)
+		for _, synonym := range synonyms {
+			suggest(synonym.TagID, SynonymMatchConfidence, "synonym "+synonym.Name)
+		}
+	}
+
+	// Tags going along with the tags of the video and the ones of its title
+	seeds := map[uint]float64{}
+	for _, tag := range existing {
+		seeds[tag.ID] = 1
+	}
+	for id, s := range suggestions {
+		seeds[id] = s.Confidence
+	}
+	if len(seeds) > 0 {
+		ids := []uint{}
+		for id := range seeds {
+			ids = append(ids, id)
+		}
+		stats := []TagStat{}
+		db.Where("tag_id IN (?) AND videos >= ?", ids, SuggestionMinVideos).Find(&stats)
+		names := tagsByID(ids)
+		for _, stat := range stats {
+			pairs := []TagPair{}
+			db.Where("tag_id = ?", stat.TagID).Find(&pairs)
+			for _, pair := range pairs {
+				share := float64(pair.Videos) / float64(stat.Videos)
+				suggest(pair.OtherID, share*CooccurrenceWeight*seeds[stat.TagID], "goes with "+names[stat.TagID].Name)
+			}
+		}
+	}
+
+	// Tags often used on the public videos of the tube, like the tag counts
+	if tubeID != 0 {
+		var total int
+		db.Model(&Video{}).Scopes(publicVideos).Where("tube_id = ?", tubeID).Count(&total)
+		if total >= SuggestionMinVideos {
+			counts := []struct {
+				TagID uint
+				Count int
+			}{}
+			db.Table("video_tags").Select("video_tags.tag_id, count(*) as count").
+				Joins("JOIN videos ON videos.id = video_tags.video_id").
+				Where("videos.tube_id = ? AND videos.deleted_at IS NULL", tubeID).Scopes(publicVideos).
+				Group("video_tags.tag_id").Scan(&counts)
+			for _, count := range counts {
+				suggest(count.TagID, float64(count.Count)/float64(total)*TubeWeight, "tube")
+			}
+		}
+	}
+
+	ids := []uint{}
+	for id, s := range suggestions {
+		if s.Confidence >= SuggestionMinConfidence {
+			ids = append(ids, id)
+		}
+	}
+	tags := tagsByID(ids)
+	result := []TagSuggestion{}
+	for _, id := range ids {
+		if tag, ok := tags[id]; ok {
+			s := suggestions[id]
+			s.Tag = tag
+			result = append(result, *s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Confidence != result[j].Confidence {
+			return result[i].Confidence > result[j].Confidence
+		}
+		return result[i].Tag.ID < result[j].Tag.ID
+	})
+	if len(result) > SuggestionLimit {
+		result = result[:SuggestionLimit]
+	}
+	return result
+}
+
+// titlePhrases returns the slugs of the sequences of up to three words of a
+// title, along with their singular when they end with s or es
+func titlePhrases(title string) []string {
+	slug := slugify(title)
+	if slug == "" {
+		return nil
+	}
+	words := strings.Split(slug, "-")
+	phrases := []string{}
+	for n := 1; n <= 3; n++ {
+		for i := 0; i+n <= len(words); i++ {
+			phrase := strings.Join(words[i:i+n], "-")
+			phrases = append(phrases, phrase)
+			if len(phrase) > 3 && strings.HasSuffix(phrase, "s") {
+				phrases = append(phrases, strings.TrimSuffix(phrase, "s"))
+				if strings.HasSuffix(phrase, "es") {
+					phrases = append(phrases, strings.TrimSuffix(phrase, "es"))
+				}
+			}
+		}
+	}
+	return phrases
+}
+
+// autoTagVideo applies to a video the suggested tags whose confidence reaches
+// AutoTagConfidence, recording the change in its history
+func autoTagVideo(video Video) []Tag {
+	applied := []Tag{}
+	if AutoTagConfidence <= 0 {
+		return applied
+	}
+	tags := []Tag{}
+	db.Model(&video).Related(&tags, "Tags")
+	for _, s := range suggestTags(video.Title, video.TubeID, tags) {
+		if s.Confidence*100 >= float64(AutoTagConfidence) {
+			applied = append(applied, s.Tag)
+		}
+	}
+	if len(applied) > 0 {
+		db.Model(&video).Association("Tags").Append(applied)
+		recordTagRevision(RevisionAutoTag, video, tags, append(append([]Tag{}, tags...), applied...))
+	}
+	return applied
+}
+
+func mapTagSuggestionRequest(r *http.Request, t *TagSuggestionRequest) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
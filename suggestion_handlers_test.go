@@ -0,0 +1,130 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagSuggestions(t *testing.T) {
+	Convey("Given tags with synonyms and videos tagged together", t, func() {
+		setupTestSuite()
+		blonde := Tag{Name: "Blonde"}
+		db.Create(&blonde)
+		db.Create(&TagSynonym{TagID: blonde.ID, Name: "Blondie", Slug: "blondie"})
+		outdoor := Tag{Name: "Outdoor"}
+		db.Create(&outdoor)
+		beach := Tag{Name: "Beach"}
+		db.Create(&beach)
+		tube := Tube{Name: "Tube"}
+		db.Create(&tube)
+		for i := 0; i < SuggestionMinVideos; i++ {
+			video := Video{Title: fmt.Sprint("video ", i), Tags: []Tag{beach, outdoor}}
+			db.Create(&video)
+			updateTagStats(video.ID)
+		}
+
+		Convey("When I ask suggestions for a new video", func() {
+			body := bytes.NewBufferString(`{"title": "Blondie at the beaches", "tags": []}`)
+			response := doRequest("POST", "/tags/suggestions", body)
+			suggestions := GetTagSuggestions{}
+			json.Unmarshal(response.Body.Bytes(), &suggestions)
+
+			Convey("Then tags in the title should come first, then the tags going with them", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(len(suggestions.Suggestions), ShouldEqual, 3)
+				So(suggestions.Suggestions[0].Tag.ID, ShouldEqual, beach.ID)
+				So(suggestions.Suggestions[0].Confidence, ShouldAlmostEqual, TitleMatchConfidence)
+				So(suggestions.Suggestions[1].Tag.ID, ShouldEqual, blonde.ID)
+				So(suggestions.Suggestions[1].Reasons, ShouldResemble, []string{"synonym Blondie"})
+				So(suggestions.Suggestions[2].Tag.ID, ShouldEqual, outdoor.ID)
+				So(suggestions.Suggestions[2].Confidence, ShouldAlmostEqual, CooccurrenceWeight*TitleMatchConfidence)
+			})
+		})
+
+		Convey("When I ask suggestions for a video which has the tag already", func() {
+			video := Video{Title: "Beach day", Tags: []Tag{beach}}
+			db.Create(&video)
+			response := doRequest("GET", fmt.Sprintf("/videos/%d/tag-suggestions", video.ID), nil)
+			suggestions := GetTagSuggestions{}
+			json.Unmarshal(response.Body.Bytes(), &suggestions)
+
+			Convey("Then only the other tags should be suggested", func() {
+				So(len(suggestions.Suggestions), ShouldEqual, 1)
+				So(suggestions.Suggestions[0].Tag.ID, ShouldEqual, outdoor.ID)
+				So(suggestions.Suggestions[0].Confidence, ShouldAlmostEqual, CooccurrenceWeight)
+			})
+		})
+
+		Convey("When automatic tagging is enabled", func() {
+			defer func(c int) { AutoTagConfidence = c }(AutoTagConfidence)
+			AutoTagConfidence = 85
+			video := Video{Title: "Beach day with a blondie"}
+			db.Create(&video)
+			applied := autoTagVideo(video)
+
+			Convey("Then only the confident suggestions should be applied", func() {
+				So(len(applied), ShouldEqual, 1)
+				So(applied[0].ID, ShouldEqual, beach.ID)
+				So(videoTagCount(beach.ID), ShouldEqual, SuggestionMinVideos+1)
+			})
+
+			Convey("Then the change should be recorded as an automatic revision", func() {
+				var revision Revision
+				So(db.Where("item_type = ? AND item_id = ? AND action = ?", "videos", video.ID, RevisionAutoTag).
+					First(&revision).RecordNotFound(), ShouldBeFalse)
+				So(string(revision.Diff), ShouldContainSubstring, `"to":["`+beach.Name+`"]`)
+			})
+		})
+
+		Convey("When an editor posts a video with automatic tagging enabled", func() {
+			defer func(c int) { AutoTagConfidence = c }(AutoTagConfidence)
+			AutoTagConfidence = 85
+			response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", "/videos",
+				bytes.NewBufferString(`{"title": "Beach day with a blondie"}`))
+			video := Video{}
+			json.Unmarshal(response.Body.Bytes(), &video)
+
+			Convey("Then the confident suggestions should be applied", func() {
+				So(len(video.Tags), ShouldEqual, 1)
+				So(video.Tags[0].ID, ShouldEqual, beach.ID)
+				So(videoTagCount(beach.ID), ShouldEqual, SuggestionMinVideos+1)
+			})
+		})
+
+		Convey("When I ask suggestions for a video of a tube with hidden videos", func() {
+			for i := 0; i < SuggestionMinVideos; i++ {
+				db.Create(&Video{Title: fmt.Sprint("public ", i), TubeID: tube.ID, Tags: []Tag{outdoor}})
+				db.Create(&Video{Title: fmt.Sprint("hidden ", i), TubeID: tube.ID, Tags: []Tag{beach}, Hidden: true})
+			}
+			body := bytes.NewBufferString(fmt.Sprintf(`{"title": "Nothing special", "tube_id": %d, "tags": []}`, tube.ID))
+			response := doRequest("POST", "/tags/suggestions", body)
+			suggestions := GetTagSuggestions{}
+			json.Unmarshal(response.Body.Bytes(), &suggestions)
+
+			Convey("Then only the tags of its public videos should be suggested", func() {
+				So(len(suggestions.Suggestions), ShouldEqual, 1)
+				So(suggestions.Suggestions[0].Tag.ID, ShouldEqual, outdoor.ID)
+				So(suggestions.Suggestions[0].Confidence, ShouldAlmostEqual, TubeWeight)
+			})
+		})
+
+		Convey("When automatic tagging is disabled", func() {
+			defer func(c int) { AutoTagConfidence = c }(AutoTagConfidence)
+			AutoTagConfidence = 0
+			video := Video{Title: "Beach day"}
+			db.Create(&video)
+
+			Convey("Then no tag should be applied", func() {
+				So(len(autoTagVideo(video)), ShouldEqual, 0)
+			})
+		})
+	})
+}
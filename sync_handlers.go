@@ -0,0 +1,305 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// SyncRunning is the status of a synchronization in progress
+	SyncRunning = "running"
+	// SyncSucceeded is the status of a synchronization that went through the
+	// whole feed
+	SyncSucceeded = "succeeded"
+	// SyncFailed is the status of a synchronization that stopped on an error
+	SyncFailed = "failed"
+
+	// SyncMaxAttempts is the number of consecutive failures on the same feed
+	// item before it is skipped
+	SyncMaxAttempts = 5
+	// SyncMaxRetryDelay caps the backoff between failed synchronizations
+	SyncMaxRetryDelay = 24 * time.Hour
+	// SyncLockTimeout is the time after which the lock of a synchronization
+	// which never finished, its process having died, is ignored
+	SyncLockTimeout = time.Hour
+)
+
+// SyncRetryDelay is the delay before retrying a failed synchronization, it
+// doubles on every consecutive failure
+var SyncRetryDelay = time.Minute
+
+// TubeSync records a synchronization run of a tube
+type TubeSync struct {
+	gorm.Model
+	Uuid       string     `json:"uuid"`
+	TubeID     uint       `json:"tube_id" gorm:"index"`
+	Status     string     `json:"status"`
+	Attempt    int        `json:"attempt"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Cursor     string     `json:"cursor"`
+	NextCursor string     `json:"next_cursor"`
+	Created    int        `json:"created"`
+	Updated    int        `json:"updated"`
+	Errors     int        `json:"errors"`
+	Log        string     `json:"log" gorm:"type:text"`
+}
+
+type GetTubeSyncs struct {
+	Nav   Navigation `json:"nav"`
+	Syncs []TubeSync `json:"syncs"`
+}
+
+// FeedItem is a video listed on a tube feed. Cursor identifies the item so
+// the next synchronization can resume after it.
+type FeedItem struct {
+	URL    string
+	Cursor string
+}
+
+// FeedProvider lists the videos a tube published after the given cursor,
+// oldest first. A MetadataProvider registered for a tube can also implement
+// FeedProvider, otherwise DefaultFeedProvider is used.
+type FeedProvider interface {
+	Feed(tube Tube, cursor string) ([]FeedItem, error)
+}
+
+// DefaultFeedProvider reads the RSS or Atom feed at the FeedURL of a tube
+var DefaultFeedProvider FeedProvider = &RSSFeedProvider{
+	Client: &http.Client{Timeout: 30 * time.Second},
+}
+
+// feedProvider returns the feed provider of a tube
+func feedProvider(tube Tube) FeedProvider {
+	if provider, ok := metadataProvider(tube).(FeedProvider); ok {
+		return provider
+	}
+	return DefaultFeedProvider
+}
+
+var TubeSyncsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tube Tube
+	if getTube(r, &tube) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit := 100
+	page := getPage(r)
+	syncs := []TubeSync{}
+	db.Where("tube_id = ?", tube.ID).Order("id desc").Offset(page * limit).Limit(limit).Find(&syncs)
+	nav := getNavigation(len(syncs), page, limit)
+
+	writeJSON(w, GetTubeSyncs{Nav: nav, Syncs: syncs})
+})
+
+// TubeSyncsPostHandler asks for a synchronization of a tube, which the
+// scheduler runs on its next check
+var TubeSyncsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tube Tube
+	if getTube(r, &tube) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if tube.SyncLockedAt != nil && tube.SyncLockedAt.After(time.Now().Add(-SyncLockTimeout)) {
+		http.Error(w, "Tube is already being synchronized", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	tube.NextSyncAt = &now
+	db.Model(&tube).UpdateColumn("next_sync_at", tube.NextSyncAt)
+	writeJSONStatus(w, http.StatusAccepted, tube)
+})
+
+// lockTubeSync takes the synchronization lock of a tube, telling whether it
+// was free. Only one run at a time, across processes, moves the cursor of a
+// tube. The lock is released by syncTube.
+func lockTubeSync(tube *Tube) bool {
+	now := time.Now()
+	query := db.Model(&Tube{}).Where("id = ? AND (sync_locked_at IS NULL OR sync_locked_at < ?)",
+		tube.ID, now.Add(-SyncLockTimeout)).UpdateColumn("sync_locked_at", &now)
+	if query.Error != nil || query.RowsAffected == 0 {
+		return false
+	}
+	// The cursor may have moved since the tube was loaded
+	return db.First(tube, tube.ID).Error == nil
+}
+
+// syncDueTubes synchronizes the tubes whose synchronization is due, on their
+// schedule or because it was asked for, and returns the runs
+func syncDueTubes() []TubeSync {
+	tubes := []Tube{}
+	db.Where("(sync_interval > 0 AND next_sync_at IS NULL) OR next_sync_at <= ?", time.Now()).Find(&tubes)
+	runs := []TubeSync{}
+	for _, tube := range tubes {
+		if lockTubeSync(&tube) {
+			runs = append(runs, syncTube(tube))
+		}
+	}
+	return runs
+}
+
+// syncTube imports the videos published on the feed of a tube since its
+// cursor, recording the run. The cursor moves forward after every imported
+// video so a failed run resumes where it stopped.
+func syncTube(tube Tube) TubeSync {
+	run := TubeSync{
+		TubeID:    tube.ID,
+		Status:    SyncRunning,
+		Attempt:   tube.SyncFailures + 1,
+		StartedAt: time.Now(),
+		Cursor:    tube.SyncCursor,
+	}
+	db.Create(&run)
+
+	cursor := tube.SyncCursor
+	items, err := feedProvider(tube).Feed(tube, cursor)
+	if err != nil {
+		run.Errors++
+		run.Log += err.Error() + "\n"
+	}
+	for _, item := range items {
+		_, created, importErr := importVideo(tube, item.URL, nil)
+		if importErr != nil {
+			run.Errors++
+			run.Log += fmt.Sprintf("%s: %s\n", item.URL, importErr)
+			if run.Attempt < SyncMaxAttempts {
+				err = importErr
+				break
+			}
+			run.Log += fmt.Sprintf("%s: skipped after %d attempts\n", item.URL, run.Attempt)
+		} else if created {
+			run.Created++
+		} else {
+			run.Updated++
+		}
+		cursor = item.Cursor
+	}
+
+	now := time.Now()
+	run.FinishedAt = &now
+	run.NextCursor = cursor
+	fields := map[string]interface{}{"sync_cursor": cursor, "sync_locked_at": nil}
+	if err != nil {
+		run.Status = SyncFailed
+		next := now.Add(syncRetryDelay(run.Attempt))
+		fields["sync_failures"] = run.Attempt
+		fields["next_sync_at"] = &next
+	} else {
+		run.Status = SyncSucceeded
+		fields["sync_failures"] = 0
+		// Tubes without a schedule wait for the next synchronization asked for
+		fields["next_sync_at"] = nil
+		if tube.SyncInterval > 0 {
+			next := now.Add(time.Duration(tube.SyncInterval) * time.Minute)
+			fields["next_sync_at"] = &next
+		}
+	}
+	db.Save(&run)
+	db.Model(&tube).UpdateColumns(fields)
+	return run
+}
+
+// syncRetryDelay returns the backoff after the given number of consecutive
+// failures
+func syncRetryDelay(failures int) time.Duration {
+	delay := SyncRetryDelay
+	for i := 1; i < failures && delay < SyncMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > SyncMaxRetryDelay {
+		delay = SyncMaxRetryDelay
+	}
+	return delay
+}
+
+// startTubeSyncScheduler synchronizes the tubes that are due on every tick of
+// the given interval
+func startTubeSyncScheduler(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, run := range syncDueTubes() {
+			log.Printf("Synchronized tube %d: %s, %d created, %d updated, %d errors",
+				run.TubeID, run.Status, run.Created, run.Updated, run.Errors)
+		}
+	}
+}
+
+// RSSFeedProvider reads RSS 2.0 and Atom feeds, which list the newest items
+// first and use the item guid (or id) as cursor
+type RSSFeedProvider struct {
+	Client *http.Client
+}
+
+type rssDocument struct {
+	Items []struct {
+		Link string `xml:"link"`
+		GUID string `xml:"guid"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func (p *RSSFeedProvider) Feed(tube Tube, cursor string) ([]FeedItem, error) {
+	if tube.FeedURL == "" {
+		return nil, errors.New("tube has no feed")
+	}
+	response, err := p.Client.Get(tube.FeedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d", tube.FeedURL, response.StatusCode)
+	}
+
+	var document rssDocument
+	if err := xml.NewDecoder(response.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	newest := []FeedItem{}
+	for _, item := range document.Items {
+		id := strings.TrimSpace(item.GUID)
+		if id == "" {
+			id = strings.TrimSpace(item.Link)
+		}
+		newest = append(newest, FeedItem{URL: strings.TrimSpace(item.Link), Cursor: id})
+	}
+	for _, entry := range document.Entries {
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				newest = append(newest, FeedItem{URL: link.Href, Cursor: strings.TrimSpace(entry.ID)})
+				break
+			}
+		}
+	}
+
+	// Keep the items published after the cursor, oldest first
+	items := []FeedItem{}
+	for _, item := range newest {
+		if cursor != "" && item.Cursor == cursor {
+			break
+		}
+		items = append([]FeedItem{item}, items...)
+	}
+	return items, nil
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// feedStandIn serves a RSS feed listing the given video ids, newest first,
+// and an OpenGraph page for every video
+func feedStandIn(ids *[]string, broken map[string]bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed" {
+			items := ""
+			for _, id := range *ids {
+				items += fmt.Sprintf("<item><link>%s/watch/%s</link><guid>%s</guid></item>", server.URL, id, id)
+			}
+			fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel>%s</channel></rss>`, items)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/watch/")
+		if broken[id] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `<meta property="og:title" content="Video %s">`, id)
+	}))
+	return server
+}
+
+func TestTubeSyncs(t *testing.T) {
+	Convey("Given a tube with a feed", t, func() {
+		setupTestSuite()
+		ids := []string{"v2", "v1"}
+		broken := map[string]bool{}
+		server := feedStandIn(&ids, broken)
+		defer server.Close()
+		tube := Tube{Name: "sync", URL: server.URL, FeedURL: server.URL + "/feed", SyncInterval: 60}
+		db.Create(&tube)
+		id := fmt.Sprint(tube.ID)
+
+		Convey("When a user without a role calls POST /tubes/{id}/syncs", func() {
+			response := doRequest("POST", "/tubes/"+id+"/syncs", nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When an editor calls POST /tubes/{id}/syncs and the scheduler checks", func() {
+			db.Model(&tube).UpdateColumn("next_sync_at", time.Now().Add(time.Hour))
+			response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", "/tubes/"+id+"/syncs", nil)
+			runs := syncDueTubes()
+
+			Convey("Then the feed videos should be created", func() {
+				So(response.Code, ShouldEqual, 202)
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].Status, ShouldEqual, SyncSucceeded)
+				So(runs[0].Created, ShouldEqual, 2)
+				So(runs[0].NextCursor, ShouldEqual, "v2")
+			})
+
+			Convey("Then the tube should remember its cursor and be unlocked", func() {
+				db.First(&tube, tube.ID)
+				So(tube.SyncCursor, ShouldEqual, "v2")
+				So(tube.NextSyncAt.After(time.Now()), ShouldBeTrue)
+				So(tube.SyncLockedAt, ShouldBeNil)
+			})
+		})
+
+		Convey("When the tube is being synchronized", func() {
+			So(lockTubeSync(&tube), ShouldBeTrue)
+
+			Convey("Then it should not be synchronized again", func() {
+				So(lockTubeSync(&tube), ShouldBeFalse)
+				So(len(syncDueTubes()), ShouldEqual, 0)
+				response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", "/tubes/"+id+"/syncs", nil)
+				So(response.Code, ShouldEqual, 409)
+			})
+		})
+
+		Convey("When new videos are published after a sync", func() {
+			syncTube(tube)
+			ids = []string{"v3", "v2", "v1"}
+			db.First(&tube, tube.ID)
+			run := syncTube(tube)
+
+			Convey("Then only the new videos should be imported", func() {
+				So(run.Created, ShouldEqual, 1)
+				So(run.Cursor, ShouldEqual, "v2")
+				So(run.NextCursor, ShouldEqual, "v3")
+			})
+
+			Convey("And the runs should be listed on GET /tubes/{id}/syncs", func() {
+				response := doRequest("GET", "/tubes/"+id+"/syncs", nil)
+				gt := GetTubeSyncs{}
+				json.Unmarshal(response.Body.Bytes(), &gt)
+				So(len(gt.Syncs), ShouldEqual, 2)
+				So(gt.Syncs[0].ID, ShouldEqual, run.ID)
+			})
+		})
+
+		Convey("When a video of the feed cannot be imported", func() {
+			broken["v2"] = true
+			run := syncTube(tube)
+
+			Convey("Then the run should fail after the videos before it", func() {
+				So(run.Status, ShouldEqual, SyncFailed)
+				So(run.Created, ShouldEqual, 1)
+				So(run.Errors, ShouldEqual, 1)
+				So(run.NextCursor, ShouldEqual, "v1")
+			})
+
+			Convey("Then the retry should be delayed with backoff", func() {
+				db.First(&tube, tube.ID)
+				So(tube.SyncFailures, ShouldEqual, 1)
+				So(tube.NextSyncAt.After(run.StartedAt), ShouldBeTrue)
+				So(syncRetryDelay(3), ShouldEqual, 4*SyncRetryDelay)
+			})
+
+			Convey("And the next run should resume from the failing video", func() {
+				delete(broken, "v2")
+				db.First(&tube, tube.ID)
+				run := syncTube(tube)
+				So(run.Status, ShouldEqual, SyncSucceeded)
+				So(run.Attempt, ShouldEqual, 2)
+				So(run.Created, ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// TagSynonym is another name of a tag, such as "blondes" for "Blonde", which
+// resolves to the tag when looking tags up
+type TagSynonym struct {
+	gorm.Model
+	Uuid  string `json:"uuid"`
+	TagID uint   `json:"tag_id" gorm:"index"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug" gorm:"unique_index"`
+}
+
+var TagSynonymsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var t TagSynonym
+	mapTagSynonym(r, &t)
+	t.Name = strings.TrimSpace(t.Name)
+	t.Slug = slugify(t.Name)
+	if t.Slug == "" {
+		http.Error(w, "Synonym name is empty", http.StatusBadRequest)
+		return
+	}
+	if other, err := findTagByName(t.Name); err == nil {
+		if other.ID == tag.ID {
+			http.Error(w, "Tag already goes by this name", http.StatusConflict)
+		} else {
+			http.Error(w, fmt.Sprintf("Name already used by tag %d", other.ID), http.StatusConflict)
+		}
+		return
+	}
+	// Synonyms of deleted tags keep their slug until they are purged
+	if !db.Unscoped().Where("slug = ?", t.Slug).First(&TagSynonym{}).RecordNotFound() {
+		http.Error(w, "Name already used by a deleted tag", http.StatusConflict)
+		return
+	}
+
+	t.TagID = tag.ID
+	db.Create(&t)
+
+	writeJSONStatus(w, http.StatusCreated, t)
+})
+
+var TagSynonymDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	var synonym TagSynonym
+	if getTag(r, &tag) != nil ||
+		findByID(db.Where("tag_id = ?", tag.ID), &synonym, mux.Vars(r)["synonym"]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Unscoped().Delete(&synonym)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+func mapTagSynonym(r *http.Request, t *TagSynonym) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+	t.Uuid = ""
+}
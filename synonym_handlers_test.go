@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagSynonyms(t *testing.T) {
+	Convey("Given a tag with a synonym", t, func() {
+		setupTestSuite()
+		tag := Tag{Name: "Blonde"}
+		db.Create(&tag)
+		id := fmt.Sprint(tag.ID)
+		response := doRequest("POST", "/tags/"+id+"/synonyms", bytes.NewBufferString(`{"name": "Blondes"}`))
+		synonym := TagSynonym{}
+		json.Unmarshal(response.Body.Bytes(), &synonym)
+
+		Convey("Then the synonym should be created", func() {
+			So(response.Code, ShouldEqual, 201)
+			So(synonym.Slug, ShouldEqual, "blondes")
+		})
+
+		Convey("Then the synonym should resolve to the tag", func() {
+			found, err := findTag("BLONDES")
+			So(err, ShouldBeNil)
+			So(found.ID, ShouldEqual, tag.ID)
+		})
+
+		Convey("Then an import naming the synonym should use the tag", func() {
+			So(findOrCreateTag("blondes").ID, ShouldEqual, tag.ID)
+		})
+
+		Convey("Then creating a tag named like the synonym should fail", func() {
+			response := doRequest("POST", "/tags", bytes.NewBufferString(`{"name": "blondes"}`))
+			So(response.Code, ShouldEqual, 409)
+		})
+
+		Convey("When I add the name of the tag as a synonym", func() {
+			response := doRequest("POST", "/tags/"+id+"/synonyms", bytes.NewBufferString(`{"name": "blonde"}`))
+
+			Convey("Then I should get a 409 response", func() {
+				So(response.Code, ShouldEqual, 409)
+			})
+		})
+
+		Convey("When I delete the synonym", func() {
+			doRequest("DELETE", fmt.Sprintf("/tags/%s/synonyms/%d", id, synonym.ID), nil)
+
+			Convey("Then it should no longer resolve", func() {
+				_, err := findTag("blondes")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
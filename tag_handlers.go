@@ -6,6 +6,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -15,9 +16,16 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
+// Tag is a category of videos. Tags form a hierarchy through ParentID, and
+// filtering videos by a tag also matches the tags below it.
 type Tag struct {
 	gorm.Model
-	Name string `json:"name"`
+	Name     string       `json:"name"`
+	Uuid     string       `json:"uuid"`
+	Slug     string       `json:"slug"`
+	ParentID *uint        `json:"parent_id" gorm:"index"`
+	Synonyms []TagSynonym `json:"synonyms,omitempty"`
+	Children []Tag        `json:"children,omitempty" gorm:"-"`
 }
 
 type GetTags struct {
@@ -29,57 +37,130 @@ var TagsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reques
 	limit := 100
 	page := 0
 	tags := []Tag{}
-	db.Limit(limit).Find(&tags).Offset(page * limit)
+	query := db
+	// List the children of a tag, or the top of the hierarchy with parent=none
+	if parent := r.URL.Query().Get("parent"); parent == "none" {
+		query = query.Where("parent_id IS NULL")
+	} else if parent != "" {
+		tag, err := findTag(parent)
+		if err != nil {
+			writeJSON(w, GetTags{Nav: getNavigation(0, page, limit), Tags: tags})
+			return
+		}
+		query = query.Where("parent_id = ?", tag.ID)
+	}
+	query.Limit(limit).Find(&tags).Offset(page * limit)
 	nav := getNavigation(len(tags), page, limit)
 
-	response, _ := json.Marshal(GetTags{Nav: nav, Tags: tags})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+	writeJSON(w, GetTags{Nav: nav, Tags: tags})
 })
 
 var TagGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tag Tag
-	getTag(r, &tag)
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db.Model(&tag).Related(&tag.Synonyms)
+	db.Where("parent_id = ?", tag.ID).Find(&tag.Children)
 
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(tag)
-	w.Write([]byte(response))
+	writeJSON(w, tag)
 })
 
 var TagsPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var t Tag
 	mapTag(r, &t)
+	if t.Slug != "" {
+		t.Slug = slugify(t.Slug)
+	}
+	if t.ParentID != nil && *t.ParentID == 0 {
+		t.ParentID = nil
+	}
+	if problem, status := validateTag(t, 0); problem != "" {
+		http.Error(w, problem, status)
+		return
+	}
 	db.Create(&t)
-	response, _ := json.Marshal(t)
-	w.Write([]byte(response))
+	writeJSON(w, t)
 })
 
 var TagsPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tag Tag
 	var updatedTag Tag
-	getTag(r, &tag)
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	mapTag(r, &updatedTag)
 
-	tag.Name = updatedTag.Name
+	// Leaving the name out, to only move the tag, keeps it
+	if updatedTag.Name != "" {
+		tag.Name = updatedTag.Name
+	}
+	// The slug is kept when the tag is renamed so that links keep working
+	if updatedTag.Slug != "" {
+		tag.Slug = slugify(updatedTag.Slug)
+	}
+	// A parent_id of 0 moves the tag to the top of the hierarchy
+	if updatedTag.ParentID != nil {
+		tag.ParentID = updatedTag.ParentID
+		if *tag.ParentID == 0 {
+			tag.ParentID = nil
+		}
+	}
+	if problem, status := validateTag(tag, tag.ID); problem != "" {
+		http.Error(w, problem, status)
+		return
+	}
 
 	db.Save(&tag)
-	response, _ := json.Marshal(tag)
-	w.Write([]byte(response))
+	writeJSON(w, tag)
 })
 
 var TagDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tag Tag
-	getTag(r, &tag)
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	db.Delete(&tag)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(""))
 })
 
-func getTag(r *http.Request, tag *Tag) {
-	vars := mux.Vars(r)
-	db.Find(&tag, vars["id"])
+// validateTag checks that the name and slug of a tag are not used by another
+// tag and that its parent exists without being below it, returning the
+// problem found and its status if any
+func validateTag(t Tag, id uint) (string, int) {
+	if other, err := findTagByName(t.Name); err == nil && other.ID != id {
+		return fmt.Sprintf("Name already used by tag %d", other.ID), http.StatusConflict
+	}
+	if t.Slug != "" && !db.Unscoped().Where("slug = ? AND id <> ?", t.Slug, id).First(&Tag{}).RecordNotFound() {
+		return "Slug already used by another tag", http.StatusConflict
+	}
+	if t.ParentID != nil {
+		if db.First(&Tag{}, *t.ParentID).RecordNotFound() {
+			return "Unknown parent tag", http.StatusUnprocessableEntity
+		}
+		if id != 0 {
+			for _, descendant := range tagFamily(id) {
+				if descendant == *t.ParentID {
+					return "A tag cannot be below itself", http.StatusUnprocessableEntity
+				}
+			}
+		}
+	}
+	return "", 0
+}
+
+// getTag loads the tag with the ID, UUID or slug of the request
+func getTag(r *http.Request, tag *Tag) error {
+	id := mux.Vars(r)["id"]
+	if findByID(db, tag, id) == nil {
+		return nil
+	}
+	return db.Where("slug = ?", id).First(tag).Error
 }
 
 func mapTag(r *http.Request, t *Tag) {
@@ -89,4 +170,8 @@ func mapTag(r *http.Request, t *Tag) {
 		log.Println("Invalid input")
 
 	}
+	t.Uuid = ""
+	// Synonyms go through their own endpoints
+	t.Synonyms = nil
+	t.Children = nil
 }
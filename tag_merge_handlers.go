@@ -0,0 +1,278 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	// RetagAdd adds the tag to the videos matching the search
+	RetagAdd = "add"
+	// RetagRemove removes the tag from the videos matching the search
+	RetagRemove = "remove"
+)
+
+// retagBatchSize is the number of videos changed by each statement of a bulk
+// retagging, which keeps under the parameter limit of the database
+const retagBatchSize = 500
+
+// TagMerge is the body of a tag merge request, naming the duplicate by ID,
+// UUID, slug or name
+type TagMerge struct {
+	Duplicate string `json:"duplicate"`
+	DryRun    bool   `json:"dry_run"`
+}
+
+// TagMergeResult tells what a tag merge changed, or would change for a dry
+// run
+type TagMergeResult struct {
+	Tag       Tag  `json:"tag"`
+	Duplicate Tag  `json:"duplicate"`
+	DryRun    bool `json:"dry_run"`
+	// Videos is the number of videos moved to the tag and Skipped the number
+	// of videos which already had both tags
+	Videos   int64 `json:"videos"`
+	Skipped  int64 `json:"skipped"`
+	Markers  int64 `json:"markers"`
+	Synonyms int64 `json:"synonyms"`
+	Children int64 `json:"children"`
+}
+
+// Retag is the body of a bulk retagging request
+type Retag struct {
+	Action string      `json:"action"`
+	Search VideoSearch `json:"search"`
+	DryRun bool        `json:"dry_run"`
+}
+
+// RetagResult tells which videos a bulk retagging changed, or would change
+// for a dry run, Videos listing the first ones
+type RetagResult struct {
+	Tag     Tag     `json:"tag"`
+	Action  string  `json:"action"`
+	DryRun  bool    `json:"dry_run"`
+	Matched int     `json:"matched"`
+	Changed int     `json:"changed"`
+	Videos  []Video `json:"videos"`
+}
+
+var TagMergeHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t TagMerge
+	mapTagMerge(r, &t)
+	duplicate, err := findTag(t.Duplicate)
+	if err != nil {
+		http.Error(w, "Duplicate tag not found", http.StatusUnprocessableEntity)
+		return
+	}
+	if duplicate.ID == tag.ID {
+		http.Error(w, "Cannot merge a tag into itself", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var videos []uint
+	db.Table("video_tags").Where("tag_id = ?", duplicate.ID).Pluck("video_id", &videos)
+	result := TagMergeResult{Tag: tag, Duplicate: duplicate, DryRun: t.DryRun}
+	err = transaction(t.DryRun, func(tx *gorm.DB) error {
+		return mergeTags(tx, tag, duplicate, &result)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !t.DryRun {
+		db.First(&result.Tag, tag.ID)
+		updateTagStats(videos...)
+	}
+
+	writeJSON(w, result)
+})
+
+var TagRetagHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var t Retag
+	mapRetag(r, &t)
+	if t.Action != RetagAdd && t.Action != RetagRemove {
+		http.Error(w, "Action must be add or remove", http.StatusBadRequest)
+		return
+	}
+	// An empty search would match the whole catalog
+	if t.Search.IsEmpty() {
+		http.Error(w, "Search has no criteria", http.StatusBadRequest)
+		return
+	}
+	query, err := searchVideos(db.Model(&Video{}), t.Search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	result := RetagResult{Tag: tag, Action: t.Action, DryRun: t.DryRun, Videos: []Video{}}
+	var changed []uint
+	err = transaction(t.DryRun, func(tx *gorm.DB) error {
+		var matched []uint
+		query.Order("videos.id").Pluck("videos.id", &matched)
+		result.Matched = len(matched)
+
+		var tagged []uint
+		tx.Table("video_tags").Where("tag_id = ?", tag.ID).Pluck("video_id", &tagged)
+		has := map[uint]bool{}
+		for _, id := range tagged {
+			has[id] = true
+		}
+		for _, id := range matched {
+			if has[id] == (t.Action == RetagRemove) {
+				changed = append(changed, id)
+			}
+		}
+		result.Changed = len(changed)
+
+		for start := 0; start < len(changed); start += retagBatchSize {
+			end := start + retagBatchSize
+			if end > len(changed) {
+				end = len(changed)
+			}
+			batch := changed[start:end]
+			var step *gorm.DB
+			if t.Action == RetagAdd {
+				step = tx.Exec("INSERT INTO video_tags (video_id, tag_id) SELECT id, ? FROM videos WHERE id IN (?)",
+					tag.ID, batch)
+			} else {
+				step = tx.Exec("DELETE FROM video_tags WHERE tag_id = ? AND video_id IN (?)", tag.ID, batch)
+			}
+			if step.Error != nil {
+				return step.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !t.DryRun {
+		updateTagStats(changed...)
+	}
+	if len(changed) > 0 {
+		sample := changed
+		if len(sample) > 100 {
+			sample = sample[:100]
+		}
+		db.Where("id IN (?)", sample).Order("id").Find(&result.Videos)
+	}
+
+	writeJSON(w, result)
+})
+
+// mergeTags moves the videos, markers, synonyms and children of a duplicate
+// onto the canonical tag, keeps the name of the duplicate as a synonym and
+// deletes the duplicate, counting the changes in result
+func mergeTags(tx *gorm.DB, tag, duplicate Tag, result *TagMergeResult) error {
+	// A tag merged into one of its descendants first takes the place of the
+	// duplicate, or it would end up below its own children
+	for _, id := range tagFamily(duplicate.ID) {
+		if id == tag.ID {
+			if err := tx.Model(&tag).UpdateColumn("parent_id", duplicate.ParentID).Error; err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// Every step runs once the previous one succeeded
+	steps := []func() error{
+		func() error {
+			move := tx.Exec("INSERT INTO video_tags (video_id, tag_id) SELECT video_id, ? FROM video_tags "+
+				"WHERE tag_id = ? AND video_id NOT IN (SELECT video_id FROM video_tags WHERE tag_id = ?)",
+				tag.ID, duplicate.ID, tag.ID)
+			result.Videos = move.RowsAffected
+			return move.Error
+		},
+		func() error {
+			remove := tx.Exec("DELETE FROM video_tags WHERE tag_id = ?", duplicate.ID)
+			result.Skipped = remove.RowsAffected - result.Videos
+			return remove.Error
+		},
+		func() error {
+			markers := tx.Model(&Marker{}).Where("tag_id = ?", duplicate.ID).UpdateColumn("tag_id", tag.ID)
+			result.Markers = markers.RowsAffected
+			return markers.Error
+		},
+		func() error {
+			synonyms := tx.Model(&TagSynonym{}).Where("tag_id = ?", duplicate.ID).UpdateColumn("tag_id", tag.ID)
+			result.Synonyms = synonyms.RowsAffected
+			return synonyms.Error
+		},
+		func() error {
+			children := tx.Model(&Tag{}).Where("parent_id = ? AND id <> ?", duplicate.ID, tag.ID).UpdateColumn("parent_id", tag.ID)
+			result.Children = children.RowsAffected
+			return children.Error
+		},
+		func() error {
+			slug := slugify(duplicate.Name)
+			if slug == "" || slug == tag.Slug || !tx.Unscoped().Where("slug = ?", slug).First(&TagSynonym{}).RecordNotFound() {
+				return nil
+			}
+			if err := tx.Create(&TagSynonym{TagID: tag.ID, Name: duplicate.Name, Slug: slug}).Error; err != nil {
+				return err
+			}
+			result.Synonyms++
+			return nil
+		},
+		func() error { return tx.Delete(&duplicate).Error },
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transaction runs f in a transaction, which is rolled back when f fails or
+// for a dry run
+func transaction(dryRun bool, f func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if dryRun {
+		return tx.Rollback().Error
+	}
+	return tx.Commit().Error
+}
+
+func mapTagMerge(r *http.Request, t *TagMerge) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
+
+func mapRetag(r *http.Request, t *Retag) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
+}
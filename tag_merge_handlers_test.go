@@ -0,0 +1,167 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func videoTagCount(tagID uint) int {
+	var count int
+	db.Table("video_tags").Where("tag_id = ?", tagID).Count(&count)
+	return count
+}
+
+func TestTagMerge(t *testing.T) {
+	Convey("Given a tag and a duplicate sharing a video", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		tag := Tag{Name: "Blonde"}
+		db.Create(&tag)
+		duplicate := Tag{Name: "Blondes"}
+		db.Create(&duplicate)
+		child := Tag{Name: "Platinum", ParentID: &duplicate.ID}
+		db.Create(&child)
+		db.Create(&Video{Title: "both", Tags: []Tag{tag, duplicate}})
+		db.Create(&Video{Title: "duplicate", Tags: []Tag{duplicate}})
+		id := fmt.Sprint(tag.ID)
+
+		Convey("When a user without a role merges them", func() {
+			response := doRequest("POST", "/tags/"+id+"/merge", bytes.NewBufferString(`{"duplicate": "blondes"}`))
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When an editor previews the merge", func() {
+			response := doRequestAs(editor, "POST", "/tags/"+id+"/merge",
+				bytes.NewBufferString(`{"duplicate": "blondes", "dry_run": true}`))
+			result := TagMergeResult{}
+			json.Unmarshal(response.Body.Bytes(), &result)
+
+			Convey("Then the changes should be reported without being made", func() {
+				So(result.DryRun, ShouldBeTrue)
+				So(result.Videos, ShouldEqual, 1)
+				So(result.Skipped, ShouldEqual, 1)
+				So(result.Children, ShouldEqual, 1)
+				So(videoTagCount(duplicate.ID), ShouldEqual, 2)
+				So(db.First(&Tag{}, duplicate.ID).RecordNotFound(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When an editor merges them", func() {
+			response := doRequestAs(editor, "POST", "/tags/"+id+"/merge", bytes.NewBufferString(`{"duplicate": "blondes"}`))
+
+			Convey("Then the videos should be moved without duplicates", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(videoTagCount(tag.ID), ShouldEqual, 2)
+				So(videoTagCount(duplicate.ID), ShouldEqual, 0)
+			})
+
+			Convey("Then the duplicate should be deleted and resolve to the tag", func() {
+				So(db.First(&Tag{}, duplicate.ID).RecordNotFound(), ShouldBeTrue)
+				found, err := findTag("Blondes")
+				So(err, ShouldBeNil)
+				So(found.ID, ShouldEqual, tag.ID)
+			})
+
+			Convey("Then the children should be moved to the tag", func() {
+				db.First(&child, child.ID)
+				So(*child.ParentID, ShouldEqual, tag.ID)
+			})
+		})
+	})
+}
+
+func TestTagMergeIntoDescendant(t *testing.T) {
+	Convey("Given a tag below a child of a duplicate", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		root := Tag{Name: "Hair"}
+		db.Create(&root)
+		duplicate := Tag{Name: "Blondes", ParentID: &root.ID}
+		db.Create(&duplicate)
+		child := Tag{Name: "Light", ParentID: &duplicate.ID}
+		db.Create(&child)
+		tag := Tag{Name: "Blonde", ParentID: &child.ID}
+		db.Create(&tag)
+
+		Convey("When an editor merges the duplicate into the tag", func() {
+			response := doRequestAs(editor, "POST", fmt.Sprintf("/tags/%d/merge", tag.ID),
+				bytes.NewBufferString(`{"duplicate": "blondes"}`))
+
+			Convey("Then the tag should take the place of the duplicate without a cycle", func() {
+				So(response.Code, ShouldEqual, 200)
+				db.First(&tag, tag.ID)
+				db.First(&child, child.ID)
+				So(*tag.ParentID, ShouldEqual, root.ID)
+				So(*child.ParentID, ShouldEqual, tag.ID)
+				So(tagFamily(root.ID), ShouldResemble, []uint{root.ID, tag.ID, child.ID})
+			})
+		})
+	})
+}
+
+func TestTagRetag(t *testing.T) {
+	Convey("Given videos matching a search", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		tag := Tag{Name: "Outdoor"}
+		db.Create(&tag)
+		db.Create(&Video{Title: "Beach day", Tags: []Tag{tag}})
+		db.Create(&Video{Title: "Beach night"})
+		db.Create(&Video{Title: "Kitchen"})
+		id := fmt.Sprint(tag.ID)
+
+		Convey("When an editor previews adding the tag", func() {
+			response := doRequestAs(editor, "POST", "/tags/"+id+"/retag",
+				bytes.NewBufferString(`{"action": "add", "search": {"title": "beach"}, "dry_run": true}`))
+			result := RetagResult{}
+			json.Unmarshal(response.Body.Bytes(), &result)
+
+			Convey("Then only the untagged video should be reported and nothing changed", func() {
+				So(result.Matched, ShouldEqual, 2)
+				So(result.Changed, ShouldEqual, 1)
+				So(result.Videos[0].Title, ShouldEqual, "Beach night")
+				So(videoTagCount(tag.ID), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When an editor adds the tag", func() {
+			doRequestAs(editor, "POST", "/tags/"+id+"/retag",
+				bytes.NewBufferString(`{"action": "add", "search": {"title": "beach"}}`))
+
+			Convey("Then every matching video should have it", func() {
+				So(videoTagCount(tag.ID), ShouldEqual, 2)
+			})
+
+			Convey("When an editor removes it with a tag search", func() {
+				response := doRequestAs(editor, "POST", "/tags/"+id+"/retag",
+					bytes.NewBufferString(`{"action": "remove", "search": {"tags": ["outdoor"]}}`))
+				result := RetagResult{}
+				json.Unmarshal(response.Body.Bytes(), &result)
+
+				Convey("Then no video should have it", func() {
+					So(result.Changed, ShouldEqual, 2)
+					So(videoTagCount(tag.ID), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When the search has no criteria", func() {
+			response := doRequestAs(editor, "POST", "/tags/"+id+"/retag", bytes.NewBufferString(`{"action": "add"}`))
+
+			Convey("Then I should get a 400 response", func() {
+				So(response.Code, ShouldEqual, 400)
+			})
+		})
+	})
+}
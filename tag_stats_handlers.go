@@ -0,0 +1,362 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// tagDayFormat is the format of the days tag counts are kept by
+const tagDayFormat = "2006-01-02"
+
+// TagStat is the number of videos having a tag
+type TagStat struct {
+	TagID  uint `gorm:"primary_key;auto_increment:false"`
+	Videos int
+}
+
+// TagDailyCount is the number of videos published on a day having a tag
+type TagDailyCount struct {
+	ID     uint   `gorm:"primary_key"`
+	TagID  uint   `gorm:"unique_index:idx_tag_daily_count"`
+	Day    string `gorm:"type:varchar(10);unique_index:idx_tag_daily_count"`
+	Videos int
+}
+
+// TagPair is the number of videos having both tags. Every pair is stored in
+// both directions.
+type TagPair struct {
+	ID      uint `gorm:"primary_key"`
+	TagID   uint `gorm:"unique_index:idx_tag_pair"`
+	OtherID uint `gorm:"unique_index:idx_tag_pair"`
+	Videos  int
+}
+
+// CountedVideoTag is a tag of a video as it is included in the tag counts,
+// which tells what to take back from the counts when the video changes
+type CountedVideoTag struct {
+	VideoID uint   `gorm:"primary_key;auto_increment:false"`
+	TagID   uint   `gorm:"primary_key;auto_increment:false;index"`
+	Day     string `gorm:"type:varchar(10)"`
+}
+
+type PopularTag struct {
+	Tag    Tag `json:"tag"`
+	Videos int `json:"videos"`
+}
+
+type GetPopularTags struct {
+	Window string       `json:"window"`
+	Tags   []PopularTag `json:"tags"`
+}
+
+// RelatedTag is a tag found on the same videos as another one. Lift tells how
+// much more often they go together than if they were independent, above 1
+// when they attract each other.
+type RelatedTag struct {
+	Tag    Tag     `json:"tag"`
+	Videos int     `json:"videos"`
+	Lift   float64 `json:"lift"`
+}
+
+type GetRelatedTags struct {
+	Tags []RelatedTag `json:"tags"`
+}
+
+var PopularTagsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = DefaultRankingWindow
+	}
+	duration, ok := RankingWindows[window]
+	if !ok {
+		http.Error(w, "Unknown window", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	counts := []struct {
+		TagID  uint
+		Videos int
+	}{}
+	if duration == 0 {
+		db.Model(&TagStat{}).Select("tag_id, videos").Where("videos > 0").
+			Order("videos desc, tag_id").Limit(limit).Scan(&counts)
+	} else {
+		since := time.Now().Add(-duration).Format(tagDayFormat)
+		db.Model(&TagDailyCount{}).Select("tag_id, sum(videos) as videos").Where("day >= ?", since).
+			Group("tag_id").Having("sum(videos) > 0").Order("videos desc, tag_id").Limit(limit).Scan(&counts)
+	}
+
+	ids := make([]uint, len(counts))
+	for i, count := range counts {
+		ids[i] = count.TagID
+	}
+	found := tagsByID(ids)
+	popular := []PopularTag{}
+	for _, count := range counts {
+		if tag, ok := found[count.TagID]; ok {
+			popular = append(popular, PopularTag{Tag: tag, Videos: count.Videos})
+		}
+	}
+
+	writeJSON(w, GetPopularTags{Window: window, Tags: popular})
+})
+
+var RelatedTagsGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if getTag(r, &tag) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	by := r.URL.Query().Get("sort")
+	if by != "" && by != "videos" && by != "lift" {
+		http.Error(w, "Unknown sort "+by, http.StatusBadRequest)
+		return
+	}
+
+	var stat TagStat
+	db.Where("tag_id = ?", tag.ID).First(&stat)
+	// Lift compares with the share of the tagged videos having each tag
+	var total int
+	db.Model(&CountedVideoTag{}).Select("count(distinct video_id)").Row().Scan(&total)
+	pairs := []TagPair{}
+	db.Where("tag_id = ? AND videos > 0", tag.ID).Find(&pairs)
+	ids := make([]uint, len(pairs))
+	for i, pair := range pairs {
+		ids[i] = pair.OtherID
+	}
+	found := tagsByID(ids)
+	others := []TagStat{}
+	if len(ids) > 0 {
+		db.Where("tag_id IN (?)", ids).Find(&others)
+	}
+	counts := map[uint]int{}
+	for _, other := range others {
+		counts[other.TagID] = other.Videos
+	}
+
+	related := []RelatedTag{}
+	for _, pair := range pairs {
+		other, ok := found[pair.OtherID]
+		if !ok || stat.Videos == 0 || counts[pair.OtherID] == 0 {
+			continue
+		}
+		lift := float64(pair.Videos) * float64(total) / (float64(stat.Videos) * float64(counts[pair.OtherID]))
+		related = append(related, RelatedTag{Tag: other, Videos: pair.Videos, Lift: lift})
+	}
+	sort.Slice(related, func(i, j int) bool {
+		a, b := related[i], related[j]
+		if by == "lift" && a.Lift != b.Lift {
+			return a.Lift > b.Lift
+		}
+		if a.Videos != b.Videos {
+			return a.Videos > b.Videos
+		}
+		return a.Tag.ID < b.Tag.ID
+	})
+	if len(related) > 100 {
+		related = related[:100]
+	}
+
+	writeJSON(w, GetRelatedTags{Tags: related})
+})
+
+// TagStatsRebuildHandler recounts the tags of every video, correcting counts
+// that drifted
+var TagStatsRebuildHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if err := rebuildTagStats(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// updateTagStats brings the tag counts up to date with the current tags of
+// the given videos, taking back what they counted for before. Only the
+// videos everybody can see count, it is called again whenever a video is
+// deleted, hidden, changes status or its upload time comes.
+func updateTagStats(videoIDs ...uint) {
+	for _, id := range videoIDs {
+		if err := updateVideoTagStats(id); err != nil {
+			log.Printf("Could not update the tag counts of video %d: %s", id, err)
+		}
+	}
+}
+
+func updateVideoTagStats(videoID uint) error {
+	return transaction(false, func(tx *gorm.DB) error {
+		// Concurrent updates of a video would both take back what it counted
+		// for, writing to its row holds the others until this one is done
+		if err := tx.Exec("UPDATE videos SET id = id WHERE id = ?", videoID).Error; err != nil {
+			return err
+		}
+		before := []CountedVideoTag{}
+		if err := tx.Where("video_id = ?", videoID).Order("tag_id").Find(&before).Error; err != nil {
+			return err
+		}
+		after := []CountedVideoTag{}
+		var video Video
+		if !tx.Scopes(publicVideos).First(&video, videoID).RecordNotFound() {
+			day := video.CreatedAt
+			if video.Uploaded != nil {
+				day = *video.Uploaded
+			}
+			var tagIDs []uint
+			tx.Table("video_tags").Where("video_id = ?", videoID).Order("tag_id").Pluck("DISTINCT tag_id", &tagIDs)
+			for _, tagID := range tagIDs {
+				after = append(after, CountedVideoTag{VideoID: videoID, TagID: tagID, Day: day.UTC().Format(tagDayFormat)})
+			}
+		}
+		if sameCountedTags(before, after) {
+			return nil
+		}
+
+		if err := countTags(tx, before, -1); err != nil {
+			return err
+		}
+		if err := countTags(tx, after, 1); err != nil {
+			return err
+		}
+		if err := tx.Where("video_id = ?", videoID).Delete(CountedVideoTag{}).Error; err != nil {
+			return err
+		}
+		for _, counted := range after {
+			if err := tx.Create(&counted).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// countTags adds delta to the counts of the tags of a video and of every pair
+// of them
+func countTags(tx *gorm.DB, tags []CountedVideoTag, delta int) error {
+	for _, t := range tags {
+		if err := addCount(tx, &TagStat{TagID: t.TagID, Videos: delta}, delta, "tag_id = ?", t.TagID); err != nil {
+			return err
+		}
+		if err := addCount(tx, &TagDailyCount{TagID: t.TagID, Day: t.Day, Videos: delta}, delta,
+			"tag_id = ? AND day = ?", t.TagID, t.Day); err != nil {
+			return err
+		}
+		for _, other := range tags {
+			if other.TagID == t.TagID {
+				continue
+			}
+			if err := addCount(tx, &TagPair{TagID: t.TagID, OtherID: other.TagID, Videos: delta}, delta,
+				"tag_id = ? AND other_id = ?", t.TagID, other.TagID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addCount adds delta to the videos of the row matching the condition,
+// creating it from row when missing and removing it when it drops to zero
+func addCount(tx *gorm.DB, row interface{}, delta int, condition string, values ...interface{}) error {
+	table := tx.NewScope(row).TableName()
+	update := tx.Table(table).Where(condition, values...).UpdateColumn("videos", gorm.Expr("videos + ?", delta))
+	if update.Error != nil {
+		return update.Error
+	}
+	if update.RowsAffected == 0 {
+		if delta < 0 {
+			return nil
+		}
+		// Another video may create the row first, the savepoint keeps the
+		// transaction going to add to that row instead
+		if err := tx.Exec("SAVEPOINT add_count").Error; err != nil {
+			return err
+		}
+		if tx.Create(row).Error == nil {
+			return tx.Exec("RELEASE SAVEPOINT add_count").Error
+		}
+		if err := tx.Exec("ROLLBACK TO SAVEPOINT add_count").Error; err != nil {
+			return err
+		}
+		return tx.Table(table).Where(condition, values...).UpdateColumn("videos", gorm.Expr("videos + ?", delta)).Error
+	}
+	return tx.Table(table).Where(condition, values...).Where("videos <= 0").Delete(row).Error
+}
+
+// tagsByID loads the tags with the given IDs, deleted tags being left out
+func tagsByID(ids []uint) map[uint]Tag {
+	found := map[uint]Tag{}
+	if len(ids) == 0 {
+		return found
+	}
+	tags := []Tag{}
+	db.Where("id IN (?)", ids).Find(&tags)
+	for _, tag := range tags {
+		found[tag.ID] = tag
+	}
+	return found
+}
+
+// sameCountedTags tells whether two lists of counted tags, sorted by tag, are
+// the same
+func sameCountedTags(a, b []CountedVideoTag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].TagID != b[i].TagID || a[i].Day != b[i].Day {
+			return false
+		}
+	}
+	return true
+}
+
+// videosCountedFor returns the videos counted for the given tags
+func videosCountedFor(tagIDs interface{}) []uint {
+	var ids []uint
+	db.Model(&CountedVideoTag{}).Where("tag_id IN (?)", tagIDs).Pluck("DISTINCT video_id", &ids)
+	return ids
+}
+
+// rebuildTagStats throws the tag counts away and counts the tags of every
+// video again
+func rebuildTagStats() error {
+	err := transaction(false, func(tx *gorm.DB) error {
+		for _, model := range []interface{}{CountedVideoTag{}, TagStat{}, TagDailyCount{}, TagPair{}} {
+			if err := tx.Where("1 = 1").Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var ids []uint
+	db.Table("video_tags").Pluck("DISTINCT video_id", &ids)
+	updateTagStats(ids...)
+	return nil
+}
+
+// migrateTagStats counts the tags of every video when the counts have never
+// been computed
+func migrateTagStats() {
+	var counted int
+	db.Model(&CountedVideoTag{}).Count(&counted)
+	if counted > 0 {
+		return
+	}
+	var ids []uint
+	db.Table("video_tags").Pluck("DISTINCT video_id", &ids)
+	updateTagStats(ids...)
+}
@@ -0,0 +1,214 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagStats(t *testing.T) {
+	Convey("Given tagged videos with their tag counts", t, func() {
+		setupTestSuite()
+		blonde := Tag{Name: "Blonde"}
+		db.Create(&blonde)
+		outdoor := Tag{Name: "Outdoor"}
+		db.Create(&outdoor)
+		kitchen := Tag{Name: "Kitchen"}
+		db.Create(&kitchen)
+		old := time.Now().AddDate(0, -2, 0)
+		videos := []Video{
+			{Title: "a", Tags: []Tag{blonde, outdoor}},
+			{Title: "b", Tags: []Tag{blonde, outdoor}},
+			{Title: "c", Tags: []Tag{blonde, kitchen}},
+			{Title: "d", Tags: []Tag{kitchen}, Uploaded: &old},
+		}
+		for i := range videos {
+			db.Create(&videos[i])
+			updateTagStats(videos[i].ID)
+		}
+
+		Convey("When I get the popular tags of the week", func() {
+			response := doRequest("GET", "/tags/popular", nil)
+			popular := GetPopularTags{}
+			json.Unmarshal(response.Body.Bytes(), &popular)
+
+			Convey("Then the tags should be counted on the videos of the window", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(len(popular.Tags), ShouldEqual, 3)
+				So(popular.Tags[0].Tag.ID, ShouldEqual, blonde.ID)
+				So(popular.Tags[0].Videos, ShouldEqual, 3)
+				So(popular.Tags[2].Tag.ID, ShouldEqual, kitchen.ID)
+				So(popular.Tags[2].Videos, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I get the popular tags of all time", func() {
+			response := doRequest("GET", "/tags/popular?window=all", nil)
+			popular := GetPopularTags{}
+			json.Unmarshal(response.Body.Bytes(), &popular)
+
+			Convey("Then older videos should count", func() {
+				So(popular.Tags[2].Tag.ID, ShouldEqual, kitchen.ID)
+				So(popular.Tags[2].Videos, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I get the tags related to outdoor", func() {
+			response := doRequest("GET", fmt.Sprintf("/tags/%d/related", outdoor.ID), nil)
+			related := GetRelatedTags{}
+			json.Unmarshal(response.Body.Bytes(), &related)
+
+			Convey("Then blonde should be listed with its lift", func() {
+				So(len(related.Tags), ShouldEqual, 1)
+				So(related.Tags[0].Tag.ID, ShouldEqual, blonde.ID)
+				So(related.Tags[0].Videos, ShouldEqual, 2)
+				So(related.Tags[0].Lift, ShouldAlmostEqual, 2.0*4/(2*3))
+			})
+		})
+
+		Convey("When a video is deleted", func() {
+			doRequest("DELETE", fmt.Sprintf("/videos/%d", videos[0].ID), nil)
+
+			Convey("Then its tags should no longer be counted", func() {
+				var stat TagStat
+				db.Where("tag_id = ?", outdoor.ID).First(&stat)
+				So(stat.Videos, ShouldEqual, 1)
+				var pair TagPair
+				db.Where("tag_id = ? AND other_id = ?", blonde.ID, outdoor.ID).First(&pair)
+				So(pair.Videos, ShouldEqual, 1)
+			})
+
+			Convey("When it is restored", func() {
+				doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", fmt.Sprintf("/videos/%d/restore", videos[0].ID), nil)
+
+				Convey("Then its tags should be counted again", func() {
+					var stat TagStat
+					db.Where("tag_id = ?", outdoor.ID).First(&stat)
+					So(stat.Videos, ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When an untagged video is added", func() {
+			db.Create(&Video{Title: "untagged"})
+			response := doRequest("GET", fmt.Sprintf("/tags/%d/related", outdoor.ID), nil)
+			related := GetRelatedTags{}
+			json.Unmarshal(response.Body.Bytes(), &related)
+
+			Convey("Then the lift should be unchanged", func() {
+				So(related.Tags[0].Lift, ShouldAlmostEqual, 2.0*4/(2*3))
+			})
+		})
+
+		Convey("When a video waiting for review is tagged", func() {
+			editor := User{Name: "editor", Role: RoleEditor}
+			pending := Video{Title: "e", Status: VideoPendingReview, Tags: []Tag{outdoor}}
+			db.Create(&pending)
+			updateTagStats(pending.ID)
+
+			Convey("Then it should not be counted", func() {
+				var stat TagStat
+				db.Where("tag_id = ?", outdoor.ID).First(&stat)
+				So(stat.Videos, ShouldEqual, 2)
+			})
+
+			Convey("When an editor approves it", func() {
+				doRequestAs(editor, "POST", fmt.Sprintf("/videos/%d/approve", pending.ID), bytes.NewBufferString(`{}`))
+
+				Convey("Then it should be counted", func() {
+					var stat TagStat
+					db.Where("tag_id = ?", outdoor.ID).First(&stat)
+					So(stat.Videos, ShouldEqual, 3)
+				})
+
+				Convey("When it is hidden by the reports", func() {
+					defer func(c int) { ReportHideThreshold = c }(ReportHideThreshold)
+					ReportHideThreshold = 1
+					db.Create(&Report{VideoID: pending.ID, UserID: 1, Status: ReportOpen})
+					db.First(&pending, pending.ID)
+					updateReportedVideo(pending)
+
+					Convey("Then it should no longer be counted", func() {
+						var stat TagStat
+						db.Where("tag_id = ?", outdoor.ID).First(&stat)
+						So(stat.Videos, ShouldEqual, 2)
+					})
+				})
+			})
+		})
+
+		Convey("When a published video is uploaded in the future", func() {
+			soon := time.Now().Add(time.Hour)
+			future := Video{Title: "f", Status: VideoPublished, Uploaded: &soon, Tags: []Tag{outdoor}}
+			db.Create(&future)
+			updateTagStats(future.ID)
+
+			Convey("Then it should not be counted", func() {
+				var stat TagStat
+				db.Where("tag_id = ?", outdoor.ID).First(&stat)
+				So(stat.Videos, ShouldEqual, 2)
+			})
+
+			Convey("When its time comes", func() {
+				defer func(t time.Time) { countedUntil = t }(countedUntil)
+				countedUntil = time.Now().Add(-time.Minute)
+				past := time.Now().Add(-time.Second)
+				db.Model(&future).UpdateColumn("uploaded", &past)
+				publishScheduledVideos()
+
+				Convey("Then it should be counted", func() {
+					var stat TagStat
+					db.Where("tag_id = ?", outdoor.ID).First(&stat)
+					So(stat.Videos, ShouldEqual, 3)
+				})
+			})
+		})
+
+		Convey("When the counts drifted", func() {
+			db.Model(&TagStat{}).Where("tag_id = ?", blonde.ID).UpdateColumn("videos", 42)
+			db.Where("tag_id = ?", kitchen.ID).Delete(TagPair{})
+
+			Convey("Then only admins should rebuild them", func() {
+				response := doRequestAs(User{Name: "editor", Role: RoleEditor}, "POST", "/tags/stats/rebuild", nil)
+				So(response.Code, ShouldEqual, 403)
+			})
+
+			Convey("When an admin rebuilds them", func() {
+				response := doRequestAs(User{Name: "admin", Role: RoleAdmin}, "POST", "/tags/stats/rebuild", nil)
+
+				Convey("Then they should be counted again", func() {
+					So(response.Code, ShouldEqual, 200)
+					var stat TagStat
+					db.Where("tag_id = ?", blonde.ID).First(&stat)
+					So(stat.Videos, ShouldEqual, 3)
+					var pair TagPair
+					db.Where("tag_id = ? AND other_id = ?", kitchen.ID, blonde.ID).First(&pair)
+					So(pair.Videos, ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When a tag is removed from every video", func() {
+			db.Exec("DELETE FROM video_tags WHERE tag_id = ?", kitchen.ID)
+			updateTagStats(videos[2].ID, videos[3].ID)
+
+			Convey("Then its counts should be removed", func() {
+				var count int
+				db.Model(&TagStat{}).Where("tag_id = ?", kitchen.ID).Count(&count)
+				So(count, ShouldEqual, 0)
+				db.Model(&TagDailyCount{}).Where("tag_id = ?", kitchen.ID).Count(&count)
+				So(count, ShouldEqual, 0)
+				db.Model(&TagPair{}).Where("other_id = ?", kitchen.ID).Count(&count)
+				So(count, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,127 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+)
+
+// slugify turns a name into a lowercase slug made of letters, digits and
+// dashes, "Blonde Hair" becoming "blonde-hair"
+func slugify(name string) string {
+	var b bytes.Buffer
+	dash := false
+	for _, c := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// uniqueTagSlug returns the slug of a name, numbered when it is already
+// taken by another tag, deleted ones included
+func uniqueTagSlug(query *gorm.DB, name string) string {
+	base := slugify(name)
+	if base == "" {
+		base = "tag"
+	}
+	slug := base
+	for i := 2; ; i++ {
+		var count int
+		query.Unscoped().Model(&Tag{}).Where("slug = ?", slug).Count(&count)
+		if count == 0 {
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// BeforeCreate gives new tags a slug when they have none
+func (t *Tag) BeforeCreate(scope *gorm.Scope) error {
+	if t.Slug == "" {
+		scope.SetColumn("Slug", uniqueTagSlug(scope.NewDB(), t.Name))
+	}
+	return nil
+}
+
+// findTag returns the tag with the given ID, UUID, slug, name or synonym,
+// ignoring case
+func findTag(value string) (Tag, error) {
+	var tag Tag
+	if findByID(db, &tag, value) == nil {
+		return tag, nil
+	}
+	return findTagByName(value)
+}
+
+// findTagByName returns the tag with the given slug, name or synonym,
+// ignoring case. Names looking like IDs, such as "2019", are not taken for
+// one.
+func findTagByName(value string) (Tag, error) {
+	var tag Tag
+	slug := slugify(value)
+	err := db.Where("slug = ? OR lower(name) = ?", slug, strings.ToLower(strings.TrimSpace(value))).First(&tag).Error
+	if err == nil {
+		return tag, nil
+	}
+	var synonym TagSynonym
+	if err := db.Where("slug = ?", slug).First(&synonym).Error; err != nil {
+		return tag, err
+	}
+	return tag, db.First(&tag, synonym.TagID).Error
+}
+
+// tagFamily returns the IDs of a tag and of all its descendants
+func tagFamily(id uint) []uint {
+	family := []uint{id}
+	seen := map[uint]bool{id: true}
+	parents := []uint{id}
+	for len(parents) > 0 {
+		var children []uint
+		db.Model(&Tag{}).Where("parent_id IN (?)", parents).Pluck("id", &children)
+		parents = nil
+		for _, child := range children {
+			if !seen[child] {
+				seen[child] = true
+				family = append(family, child)
+				parents = append(parents, child)
+			}
+		}
+	}
+	return family
+}
+
+// withTags restricts a video query to the videos having the tag or one of
+// its descendants
+func withTags(query *gorm.DB, tag Tag) *gorm.DB {
+	var ids []uint
+	db.Table("video_tags").Where("tag_id IN (?)", tagFamily(tag.ID)).Pluck("video_id", &ids)
+	return query.Where("videos.id IN (?)", ids)
+}
+
+// migrateTagSlugs gives a slug to the tags created before slugs existed and
+// makes slugs unique
+func migrateTagSlugs() {
+	var ids []uint
+	db.Unscoped().Model(&Tag{}).Where("slug IS NULL OR slug = ''").Pluck("id", &ids)
+	for _, id := range ids {
+		var tag Tag
+		db.Unscoped().First(&tag, id)
+		db.Unscoped().Model(&tag).UpdateColumn("slug", uniqueTagSlug(db, tag.Name))
+	}
+	db.Model(&Tag{}).AddUniqueIndex("idx_tags_slug", "slug")
+}
@@ -0,0 +1,113 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSlugify(t *testing.T) {
+	Convey("Given tag names", t, func() {
+		Convey("Then they should become lowercase slugs", func() {
+			So(slugify("Blonde Hair"), ShouldEqual, "blonde-hair")
+			So(slugify("  Big & Beautiful! "), ShouldEqual, "big-beautiful")
+			So(slugify("Crème brûlée"), ShouldEqual, "crème-brûlée")
+			So(slugify("!!!"), ShouldEqual, "")
+		})
+	})
+}
+
+func TestTagHierarchy(t *testing.T) {
+	Convey("Given a parent tag with a child and a grandchild", t, func() {
+		setupTestSuite()
+		hair := Tag{Name: "Hair"}
+		db.Create(&hair)
+		blonde := Tag{Name: "Blonde", ParentID: &hair.ID}
+		db.Create(&blonde)
+		platinum := Tag{Name: "Platinum Blonde", ParentID: &blonde.ID}
+		db.Create(&platinum)
+
+		Convey("Then the tags should get unique slugs", func() {
+			other := Tag{Name: "blonde"}
+			db.Create(&other)
+			So(platinum.Slug, ShouldEqual, "platinum-blonde")
+			So(other.Slug, ShouldEqual, "blonde-2")
+		})
+
+		Convey("Then the family of the parent should include every descendant", func() {
+			So(tagFamily(hair.ID), ShouldResemble, []uint{hair.ID, blonde.ID, platinum.ID})
+		})
+
+		Convey("When I get the tag by its slug", func() {
+			response := doRequest("GET", "/tags/blonde", nil)
+			tag := Tag{}
+			json.Unmarshal(response.Body.Bytes(), &tag)
+
+			Convey("Then its children should be listed", func() {
+				So(tag.ID, ShouldEqual, blonde.ID)
+				So(len(tag.Children), ShouldEqual, 1)
+				So(tag.Children[0].ID, ShouldEqual, platinum.ID)
+			})
+		})
+
+		Convey("When I list the top of the hierarchy", func() {
+			response := doRequest("GET", "/tags?parent=none", nil)
+			tags := GetTags{}
+			json.Unmarshal(response.Body.Bytes(), &tags)
+
+			Convey("Then only the parent should be listed", func() {
+				So(len(tags.Tags), ShouldEqual, 1)
+				So(tags.Tags[0].ID, ShouldEqual, hair.ID)
+			})
+		})
+
+		Convey("When I move the parent below its grandchild", func() {
+			body := bytes.NewBufferString(fmt.Sprintf(`{"name": "Hair", "parent_id": %d}`, platinum.ID))
+			response := doRequest("PATCH", fmt.Sprintf("/tags/%d", hair.ID), body)
+
+			Convey("Then I should get a 422 response", func() {
+				So(response.Code, ShouldEqual, 422)
+			})
+		})
+
+		Convey("When I only move a tag to another parent", func() {
+			body := bytes.NewBufferString(fmt.Sprintf(`{"parent_id": %d}`, hair.ID))
+			response := doRequest("PATCH", fmt.Sprintf("/tags/%d", platinum.ID), body)
+			var tag Tag
+			db.First(&tag, platinum.ID)
+
+			Convey("Then its name should be kept", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(tag.Name, ShouldEqual, "Platinum Blonde")
+				So(*tag.ParentID, ShouldEqual, hair.ID)
+			})
+		})
+
+		Convey("When I create a tag whose name has the slug of another one", func() {
+			response := doRequest("POST", "/tags", bytes.NewBufferString(`{"name": "platinum-blonde"}`))
+
+			Convey("Then I should get a 409 response", func() {
+				So(response.Code, ShouldEqual, 409)
+			})
+		})
+
+		Convey("When I rename a tag", func() {
+			doRequest("PATCH", fmt.Sprintf("/tags/%d", blonde.ID), bytes.NewBufferString(`{"name": "Blond"}`))
+			var tag Tag
+			db.First(&tag, blonde.ID)
+
+			Convey("Then its slug and parent should be kept", func() {
+				So(tag.Name, ShouldEqual, "Blond")
+				So(tag.Slug, ShouldEqual, "blonde")
+				So(*tag.ParentID, ShouldEqual, hair.ID)
+			})
+		})
+	})
+}
@@ -0,0 +1,271 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// trashResource describes a soft-deletable resource and the rows of other
+// tables that go away with it once it is purged
+type trashResource struct {
+	model func() interface{}
+	list  func() interface{}
+	// dependents maps a table to its column referencing the resource
+	dependents map[string]string
+	// purged removes, within the purge transaction, the rows referencing the
+	// given items which dependents cannot describe
+	purged func(tx *gorm.DB, ids []interface{}) error
+	// restored is called with the items which were brought back, before
+	// changed
+	restored func(ids []interface{})
+	// files returns the stored files to remove along with the given items
+	files func(ids []interface{}) []string
+	// changed is called with the items which were restored or purged
+	changed func(ids []interface{})
+}
+
+var trashResources = map[string]trashResource{
+	"tubes": {
+		model:      func() interface{} { return &Tube{} },
+		list:       func() interface{} { return &[]Tube{} },
+		dependents: map[string]string{"tube_syncs": "tube_id"},
+	},
+	"tags": {
+		model: func() interface{} { return &Tag{} },
+		list:  func() interface{} { return &[]Tag{} },
+		dependents: map[string]string{
+			"video_tags":         "tag_id",
+			"tag_synonyms":       "tag_id",
+			"tag_stats":          "tag_id",
+			"tag_daily_counts":   "tag_id",
+			"counted_video_tags": "tag_id",
+		},
+		purged: func(tx *gorm.DB, ids []interface{}) error {
+			// Children move up to the parent of the purged tag, which is
+			// loaded again in case it was just purged too
+			for _, id := range ids {
+				var tag Tag
+				if err := tx.Unscoped().First(&tag, id).Error; err != nil {
+					return err
+				}
+				if err := tx.Unscoped().Model(&Tag{}).Where("parent_id = ?", tag.ID).
+					UpdateColumn("parent_id", tag.ParentID).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Exec("DELETE FROM tag_pairs WHERE tag_id IN (?) OR other_id IN (?)", ids, ids).Error
+		},
+		changed: func(ids []interface{}) {
+			updateTagStats(videosCountedFor(ids)...)
+		},
+	},
+	"actors": {
+		model: func() interface{} { return &Actor{} },
+		list:  func() interface{} { return &[]Actor{} },
+		// Compliance records and documents are kept, the law requires them
+		// for years after the content is gone
+		dependents: map[string]string{
+			"video_actors":  "actor_id",
+			"actor_aliases": "actor_id",
+			"social_links":  "actor_id",
+			"photos":        "actor_id",
+		},
+		// Redirects from or to a purged actor lead nowhere
+		purged: func(tx *gorm.DB, ids []interface{}) error {
+			return tx.Exec("DELETE FROM actor_redirects WHERE from_id IN (?) OR to_id IN (?)", ids, ids).Error
+		},
+		// A merged duplicate brought back is addressed again instead of
+		// redirecting to the actor it was merged into
+		restored: func(ids []interface{}) {
+			db.Exec("DELETE FROM actor_redirects WHERE from_id IN (?)", ids)
+		},
+		files: func(ids []interface{}) []string {
+			photos := []Photo{}
+			db.Unscoped().Where("actor_id IN (?)", ids).Find(&photos)
+			files := []string{}
+			for _, photo := range photos {
+				files = append(files, photo.File, photo.Thumbnail)
+			}
+			return files
+		},
+	},
+	"videos": {
+		model: func() interface{} { return &Video{} },
+		list:  func() interface{} { return &[]Video{} },
+		// Production records are kept for the same reason as the compliance
+		// records of actors. The tag counts of a video are taken back when it
+		// is deleted, and again by changed.
+		dependents: map[string]string{
+			"video_tags":     "video_id",
+			"video_actors":   "video_id",
+			"ratings":        "video_id",
+			"watches":        "video_id",
+			"video_rankings": "video_id",
+			"reports":        "video_id",
+			"comments":       "video_id",
+			"markers":        "video_id",
+		},
+		changed: func(ids []interface{}) {
+			for _, id := range ids {
+				updateTagStats(id.(uint))
+			}
+		},
+	},
+	"users": {
+		model:      func() interface{} { return &User{} },
+		list:       func() interface{} { return &[]User{} },
+		dependents: map[string]string{"ratings": "user_id", "watches": "user_id"},
+	},
+}
+
+type GetTrash struct {
+	Nav   Navigation  `json:"nav"`
+	Items interface{} `json:"items"`
+}
+
+var TrashGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	resource, ok := trashResources[mux.Vars(r)["resource"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit := 100
+	page := getPage(r)
+	items := resource.list()
+	query := trashed().Order("deleted_at desc").Offset(page * limit).Limit(limit).Find(items)
+	nav := getNavigation(int(query.RowsAffected), page, limit)
+
+	writeJSON(w, GetTrash{Nav: nav, Items: items})
+})
+
+var TrashDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	resource, ok := trashResources[mux.Vars(r)["resource"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	item := resource.model()
+	if findByID(trashed(), item, mux.Vars(r)["id"]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := db.NewScope(item).PrimaryKeyValue()
+	if err := purge(resource, []interface{}{id}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+})
+
+// restoreHandler brings a soft-deleted item of the given resource back
+func restoreHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource := trashResources[name]
+		item := resource.model()
+		if findByID(trashed(), item, mux.Vars(r)["id"]) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		db.Unscoped().Model(item).UpdateColumn("deleted_at", nil)
+		id := db.NewScope(item).PrimaryKeyValue()
+		db.First(item, id)
+		if resource.restored != nil {
+			resource.restored([]interface{}{id})
+		}
+		if resource.changed != nil {
+			resource.changed([]interface{}{id})
+		}
+
+		writeJSON(w, item)
+	}
+}
+
+// trashed returns a query on soft-deleted rows only
+func trashed() *gorm.DB {
+	return db.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+// purge permanently removes the given items of a resource along with the
+// rows referencing them
+func purge(resource trashResource, ids []interface{}) error {
+	var files []string
+	if resource.files != nil {
+		files = resource.files(ids)
+	}
+	tx := db.Begin()
+	for table, column := range resource.dependents {
+		if err := tx.Exec("DELETE FROM "+table+" WHERE "+column+" IN (?)", ids).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if resource.purged != nil {
+		if err := resource.purged(tx, ids); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("id IN (?)", ids).Delete(resource.model()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if resource.changed != nil {
+		resource.changed(ids)
+	}
+	for _, file := range files {
+		if err := PhotoStorage.Delete(file); err != nil {
+			log.Printf("Could not delete %s: %s", file, err)
+		}
+	}
+	return nil
+}
+
+// purgeTrash permanently removes every item deleted before the given time
+// and returns how many were removed
+func purgeTrash(before time.Time) int {
+	n := 0
+	for name, resource := range trashResources {
+		var ids []uint
+		trashed().Model(resource.model()).Where("deleted_at < ?", before).Pluck("id", &ids)
+		if len(ids) == 0 {
+			continue
+		}
+		values := make([]interface{}, len(ids))
+		for i, id := range ids {
+			values[i] = id
+		}
+		if err := purge(resource, values); err != nil {
+			log.Printf("Could not purge %s: %s", name, err)
+			continue
+		}
+		n += len(ids)
+	}
+	return n
+}
+
+// startTrashPurger removes the items that have been in the trash for longer
+// than the retention period, every hour
+func startTrashPurger(retention time.Duration) {
+	for range time.Tick(time.Hour) {
+		if n := purgeTrash(time.Now().Add(-retention)); n > 0 {
+			log.Printf("Purged %d items from the trash", n)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrash(t *testing.T) {
+	Convey("Given a deleted video with tags and ratings", t, func() {
+		setupTestSuite()
+		editor := User{Name: "editor", Role: RoleEditor}
+		admin := User{Name: "admin", Role: RoleAdmin}
+		v := Video{Title: "test", Tags: []Tag{{Name: "tag"}}}
+		db.Create(&v)
+		db.Create(&Rating{VideoID: v.ID, UserID: 1, Value: 4})
+		id := fmt.Sprint(v.ID)
+		doRequestAs(editor, "DELETE", "/videos/"+id, nil)
+
+		Convey("When an editor calls GET /trash/videos", func() {
+			response := doRequestAs(editor, "GET", "/trash/videos", nil)
+
+			Convey("Then the deleted video should be listed", func() {
+				trash := struct {
+					Items []Video `json:"items"`
+				}{}
+				json.Unmarshal(response.Body.Bytes(), &trash)
+				So(len(trash.Items), ShouldEqual, 1)
+				So(trash.Items[0].ID, ShouldEqual, v.ID)
+			})
+		})
+
+		Convey("When a user without a role calls GET /trash/videos", func() {
+			response := doRequest("GET", "/trash/videos", nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When an editor restores the video", func() {
+			response := doRequestAs(editor, "POST", "/videos/"+id+"/restore", nil)
+
+			Convey("Then the video should be back", func() {
+				So(response.Code, ShouldEqual, 200)
+				So(db.First(&Video{}, v.ID).RecordNotFound(), ShouldBeFalse)
+			})
+
+			Convey("Then restoring it again should not find it", func() {
+				response := doRequestAs(editor, "POST", "/videos/"+id+"/restore", nil)
+				So(response.Code, ShouldEqual, 404)
+			})
+		})
+
+		Convey("When an admin purges the video", func() {
+			response := doRequestAs(admin, "DELETE", "/trash/videos/"+id, nil)
+
+			Convey("Then the video and its dependent rows should be gone", func() {
+				var n int
+				So(response.Code, ShouldEqual, 200)
+				db.Unscoped().Model(&Video{}).Where("id = ?", v.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				db.Table("video_tags").Where("video_id = ?", v.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				db.Model(&Rating{}).Where("video_id = ?", v.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+			})
+
+			Convey("Then the tag should be kept", func() {
+				So(db.First(&Tag{}, v.Tags[0].ID).RecordNotFound(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When an editor tries to purge the video", func() {
+			response := doRequestAs(editor, "DELETE", "/trash/videos/"+id, nil)
+
+			Convey("Then I should get a 403 response", func() {
+				So(response.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When the retention period is over", func() {
+			n := purgeTrash(time.Now().Add(time.Minute))
+
+			Convey("Then the video should be purged", func() {
+				So(n, ShouldEqual, 1)
+				So(db.Unscoped().First(&Video{}, v.ID).RecordNotFound(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the retention period is not over", func() {
+			n := purgeTrash(time.Now().Add(-time.Hour))
+
+			Convey("Then nothing should be purged", func() {
+				So(n, ShouldEqual, 0)
+			})
+		})
+	})
+	Convey("Given a deleted tag with a child and counts", t, func() {
+		setupTestSuite()
+		admin := User{Name: "admin", Role: RoleAdmin}
+		parent := Tag{Name: "parent"}
+		db.Create(&parent)
+		tag := Tag{Name: "tag", ParentID: &parent.ID}
+		db.Create(&tag)
+		child := Tag{Name: "child", ParentID: &tag.ID}
+		db.Create(&child)
+		other := Tag{Name: "other"}
+		db.Create(&other)
+		v := Video{Title: "test", Tags: []Tag{tag, other}}
+		db.Create(&v)
+		updateTagStats(v.ID)
+		db.Delete(&tag)
+
+		Convey("When an admin purges it", func() {
+			response := doRequestAs(admin, "DELETE", fmt.Sprintf("/trash/tags/%d", tag.ID), nil)
+
+			Convey("Then its child should move up to its parent", func() {
+				So(response.Code, ShouldEqual, 200)
+				db.First(&child, child.ID)
+				So(*child.ParentID, ShouldEqual, parent.ID)
+			})
+
+			Convey("Then its counts should be removed", func() {
+				var n int
+				db.Model(&TagStat{}).Where("tag_id = ?", tag.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				db.Model(&TagDailyCount{}).Where("tag_id = ?", tag.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				db.Model(&TagPair{}).Where("tag_id = ? OR other_id = ?", tag.ID, tag.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				db.Model(&CountedVideoTag{}).Where("tag_id = ?", tag.ID).Count(&n)
+				So(n, ShouldEqual, 0)
+				var stat TagStat
+				db.Where("tag_id = ?", other.ID).First(&stat)
+				So(stat.Videos, ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -17,8 +18,24 @@ import (
 
 type Tube struct {
 	gorm.Model
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name         string     `json:"name"`
+	Uuid         string     `json:"uuid"`
+	URL          string     `json:"url"`
+	FeedURL      string     `json:"feed_url"`
+	SyncInterval int        `json:"sync_interval"`
+	SyncCursor   string     `json:"sync_cursor"`
+	SyncFailures int        `json:"sync_failures"`
+	NextSyncAt   *time.Time `json:"next_sync_at"`
+	SyncLockedAt *time.Time `json:"sync_locked_at"`
+}
+
+// TubeUpdate is the body of a tube update, the fields left out keeping their
+// value
+type TubeUpdate struct {
+	Name         *string `json:"name"`
+	URL          *string `json:"url"`
+	FeedURL      *string `json:"feed_url"`
+	SyncInterval *int    `json:"sync_interval"`
 }
 
 type GetTubes struct {
@@ -33,55 +50,71 @@ var TubesGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 	db.Limit(limit).Find(&tubes).Offset(page * limit)
 	nav := getNavigation(len(tubes), page, limit)
 
-	response, _ := json.Marshal(GetTubes{Nav: nav, Tubes: tubes})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+	writeJSON(w, GetTubes{Nav: nav, Tubes: tubes})
 })
 
 var TubeGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tube Tube
-	getTube(r, &tube)
+	if getTube(r, &tube) != nil {
+		http.NotFound(w, r)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(tube)
-	w.Write([]byte(response))
+	writeJSON(w, tube)
 })
 
 var TubesPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var t Tube
 	mapBody(r, &t)
 	db.Create(&t)
-	response, _ := json.Marshal(t)
-	w.Write([]byte(response))
+	writeJSON(w, t)
 })
 
 var TubesPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tube Tube
-	var updatedTube Tube
-	getTube(r, &tube)
-	mapBody(r, &updatedTube)
-
-	tube.Name = updatedTube.Name
-	tube.URL = updatedTube.URL
+	var t TubeUpdate
+	if getTube(r, &tube) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	mapTubeUpdate(r, &t)
 
-	db.Save(&tube)
-	response, _ := json.Marshal(tube)
-	w.Write([]byte(response))
+	// Only the given columns are written, the sync state may be changing
+	// under a running synchronization
+	fields := map[string]interface{}{}
+	if t.Name != nil {
+		fields["name"] = *t.Name
+	}
+	if t.URL != nil {
+		fields["url"] = *t.URL
+	}
+	if t.FeedURL != nil {
+		fields["feed_url"] = *t.FeedURL
+	}
+	if t.SyncInterval != nil {
+		fields["sync_interval"] = *t.SyncInterval
+	}
+	if len(fields) > 0 {
+		db.Model(&tube).Updates(fields)
+	}
+	db.First(&tube, tube.ID)
+	writeJSON(w, tube)
 })
 
 var TubeDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var tube Tube
-	getTube(r, &tube)
+	if getTube(r, &tube) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	db.Delete(&tube)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(""))
 })
 
-func getTube(r *http.Request, tube *Tube) {
-	vars := mux.Vars(r)
-	db.Find(&tube, vars["id"])
+func getTube(r *http.Request, tube *Tube) error {
+	return findByID(db, tube, mux.Vars(r)["id"])
 }
 
 func mapBody(r *http.Request, t *Tube) {
@@ -91,4 +124,18 @@ func mapBody(r *http.Request, t *Tube) {
 		log.Println("Invalid input")
 
 	}
+	t.Uuid = ""
+	// Sync state is maintained by the tube synchronization only
+	t.SyncCursor = ""
+	t.SyncFailures = 0
+	t.NextSyncAt = nil
+	t.SyncLockedAt = nil
+}
+
+func mapTubeUpdate(r *http.Request, t *TubeUpdate) {
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&t)
+	if err != nil {
+		log.Println("Invalid input")
+	}
 }
@@ -158,6 +158,22 @@ func TestPatchTube(t *testing.T) {
 				So(status, ShouldEqual, 200)
 			})
 		})
+
+		Convey("When I only PATCH the name of a synchronized tube", func() {
+			db.Model(&t).UpdateColumns(map[string]interface{}{
+				"feed_url": "http://test.com/feed", "sync_interval": 60, "sync_cursor": "v1"})
+			doRequest("PATCH", fmt.Sprintf("/tubes/%d", t.ID), bytes.NewBufferString(`{"name": "renamed"}`))
+
+			Convey("Then the other columns should be kept", func() {
+				tube := Tube{}
+				db.First(&tube, t.ID)
+				So(tube.Name, ShouldEqual, "renamed")
+				So(tube.URL, ShouldEqual, "http://test.com")
+				So(tube.FeedURL, ShouldEqual, "http://test.com/feed")
+				So(tube.SyncInterval, ShouldEqual, 60)
+				So(tube.SyncCursor, ShouldEqual, "v1")
+			})
+		})
 	})
 }
 
@@ -26,9 +26,19 @@ const (
 	HashSize = 64
 )
 
+const (
+	// RoleAdmin can do everything
+	RoleAdmin = "admin"
+	// RoleEditor curates the catalog
+	RoleEditor = "editor"
+	// RoleModerator handles the community
+	RoleModerator = "moderator"
+)
+
 type User struct {
 	gorm.Model
 	Name     string `json:"name"`
+	Uuid     string `json:"uuid"`
 	UserName string `json:"username"`
 	Password string `json:"password"`
 	Salt     string `json:"salt"`
@@ -47,19 +57,17 @@ var UsersGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Reque
 	db.Limit(limit).Find(&users).Offset(page * limit)
 	nav := getNavigation(len(users), page, limit)
 
-	response, _ := json.Marshal(GetUsers{Nav: nav, Users: users})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+	writeJSON(w, GetUsers{Nav: nav, Users: users})
 })
 
 var UserGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var user User
-	getUser(r, &user)
+	if getUser(r, &user) != nil {
+		http.NotFound(w, r)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(user)
-	w.Write([]byte(response))
+	writeJSON(w, user)
 })
 
 var UsersPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -82,35 +90,38 @@ var UsersPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Requ
 	t.Password = base64.StdEncoding.EncodeToString(hash)
 
 	db.Create(&t)
-	response, _ := json.Marshal(t)
-	w.Write([]byte(response))
+	writeJSON(w, t)
 })
 
 var UsersPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var user User
 	var updatedUser User
-	getUser(r, &user)
+	if getUser(r, &user) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	mapUser(r, &updatedUser)
 
 	user.Name = updatedUser.Name
 
 	db.Save(&user)
-	response, _ := json.Marshal(user)
-	w.Write([]byte(response))
+	writeJSON(w, user)
 })
 
 var UserDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var user User
-	getUser(r, &user)
+	if getUser(r, &user) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	db.Delete(&user)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(""))
 })
 
-func getUser(r *http.Request, user *User) {
-	vars := mux.Vars(r)
-	db.Find(&user, vars["id"])
+func getUser(r *http.Request, user *User) error {
+	return findByID(db, user, mux.Vars(r)["id"])
 }
 
 func mapUser(r *http.Request, t *User) {
@@ -119,6 +130,7 @@ func mapUser(r *http.Request, t *User) {
 	if err != nil {
 		log.Println("Invalid input")
 	}
+	t.Uuid = ""
 	t.Salt = ""
 	t.Role = ""
 }
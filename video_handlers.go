@@ -8,6 +8,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -22,7 +25,10 @@ type Video struct {
 	URL          string     `json:"url"`
 	ExtID        string     `json:"extid"`
 	Duration     string     `json:"duration"`
-	Rating       int        `json:"rating"`
+	Rating       float64    `json:"rating"`
+	RatingCount  int        `json:"rating_count"`
+	RatingScore  float64    `json:"rating_score"`
+	UserRating   int        `json:"user_rating,omitempty" gorm:"-"`
 	Embed        string     `json:"embed"`
 	SmallImages  string     `json:"small_images"`
 	MediumImages string     `json:"medium_images"`
@@ -34,7 +40,18 @@ type Video struct {
 	Tags         []Tag      `json:"tags" gorm:"many2many:video_tags;"`
 	Actors       []Actor    `json:"actors" gorm:"many2many:video_actors;"`
 	Tube         Tube       `json:"tube"`
+	TubeID       uint       `json:"tube_id"`
 	Uploaded     *time.Time `json:"uploaded"`
+	LinkStatus   string     `json:"link_status"`
+	LinkChecked  *time.Time `json:"link_checked"`
+	LinkFailures int        `json:"link_failures"`
+	Hidden       bool       `json:"hidden" gorm:"not null;default:false"`
+	HiddenReason string     `json:"hidden_reason,omitempty"`
+	Status       string     `json:"status" gorm:"not null;default:'published';index"`
+	AuthorID     uint       `json:"author_id"`
+	ReviewNote   string     `json:"review_note,omitempty"`
+	ReviewedBy   uint       `json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
 }
 
 type GetVideos struct {
@@ -44,59 +61,119 @@ type GetVideos struct {
 
 var VideosGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	limit := 100
-	page := 0
+	page := getPage(r)
 	videos := []Video{}
-	db.Limit(limit).Find(&videos).Offset(page * limit)
+	query := db.Scopes(visibleVideos(r))
+	if status := r.URL.Query().Get("status"); status != "" && isEditor(currentUser(r)) {
+		query = query.Where("status = ?", status)
+	}
+	if name := r.URL.Query().Get("tag"); name != "" {
+		tag, err := findTag(name)
+		if err != nil {
+			writeJSON(w, GetVideos{Nav: getNavigation(0, page, limit), Videos: videos})
+			return
+		}
+		query = withTags(query, tag)
+	}
+	query.Offset(page * limit).Limit(limit).Find(&videos)
+	setUserRatings(currentUser(r), videos)
 	nav := getNavigation(len(videos), page, limit)
 
-	response, _ := json.Marshal(GetVideos{Nav: nav, Videos: videos})
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(response))
+	writeJSON(w, GetVideos{Nav: nav, Videos: videos})
 })
 
 var VideoGetHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var video Video
-	getVideo(r, &video)
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	video.UserRating = getUserRating(currentUser(r), video.ID)
+	recordWatch(currentUser(r), video.ID)
 
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(video)
-	w.Write([]byte(response))
+	writeJSON(w, video)
 })
 
 var VideosPostHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var t Video
 	mapVideo(r, &t)
+	t.AuthorID = currentUser(r).ID
+	// Only editors publish right away, everything else goes through review
+	if !isEditor(currentUser(r)) {
+		t.Status = VideoPendingReview
+	} else if _, ok := videoTransitions[t.Status]; !ok || t.Status == VideoPublished || t.Status == VideoScheduled {
+		t.Status = publishStatus(t)
+	}
+	sanitizeVideoEmbed(&t)
 	db.Create(&t)
-	response, _ := json.Marshal(t)
-	w.Write([]byte(response))
+	if t.Status == VideoPublished {
+		emit(EventVideoPublished, t)
+	}
+	recordRevision(currentUser(r).ID, RevisionCreate, "videos", t.ID, nil, t)
+	t.Tags = append(t.Tags, autoTagVideo(t)...)
+	updateTagStats(t.ID)
+	writeJSON(w, t)
 })
 
 var VideosPatchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var video Video
 	var updatedVideo Video
-	getVideo(r, &video)
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	mapVideo(r, &updatedVideo)
 
+	before := video
 	video.Title = updatedVideo.Title
 
 	db.Save(&video)
-	response, _ := json.Marshal(video)
-	w.Write([]byte(response))
+	recordRevision(currentUser(r).ID, RevisionUpdate, "videos", video.ID, before, video)
+	writeJSON(w, video)
 })
 
 var VideoDeleteHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	var video Video
-	getVideo(r, &video)
+	if getVideo(r, &video) != nil {
+		http.NotFound(w, r)
+		return
+	}
 	db.Delete(&video)
+	recordRevision(currentUser(r).ID, RevisionDelete, "videos", video.ID, video, nil)
+	updateTagStats(video.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(""))
 })
 
-func getVideo(r *http.Request, video *Video) {
-	vars := mux.Vars(r)
-	db.Find(&video, vars["id"])
+func getVideo(r *http.Request, video *Video) error {
+	return findByID(db.Scopes(visibleVideos(r)), video, mux.Vars(r)["id"])
+}
+
+// visibleVideos limits a video query to the videos the requester may see,
+// editors see the whole catalog while everybody else only sees published
+// videos whose upload time has come and their own submissions
+func visibleVideos(r *http.Request) func(*gorm.DB) *gorm.DB {
+	u := currentUser(r)
+	return func(query *gorm.DB) *gorm.DB {
+		if isEditor(u) {
+			return query
+		}
+		if u.ID != 0 {
+			return query.Where("("+publicVideo+") OR videos.author_id = ?", false, VideoPublished, time.Now(), u.ID)
+		}
+		return publicVideos(query)
+	}
+}
+
+// publicVideo is the condition met by the videos everyone can see, given
+// false, VideoPublished and the current time
+const publicVideo = "videos.hidden = ? AND videos.status = ? AND (videos.uploaded IS NULL OR videos.uploaded <= ?)"
+
+// publicVideos restricts a query to the videos everyone can see: published,
+// not hidden and already uploaded
+func publicVideos(query *gorm.DB) *gorm.DB {
+	return query.Where(publicVideo, false, VideoPublished, time.Now())
 }
 
 func mapVideo(r *http.Request, t *Video) {
@@ -106,4 +183,50 @@ func mapVideo(r *http.Request, t *Video) {
 		log.Println("Invalid input")
 
 	}
+	// UUIDs are generated by the server
+	t.Uuid = ""
+	// Videos are attached to existing tubes by tube_id only
+	t.Tube = Tube{}
+	// Rating aggregates are maintained from the ratings table only
+	t.Rating = 0
+	t.RatingCount = 0
+	t.RatingScore = 0
+	// Reviews go through the moderation endpoints
+	t.AuthorID = 0
+	t.ReviewNote = ""
+	t.ReviewedBy = 0
+	t.ReviewedAt = nil
+	// Link checks are maintained by the link checker only
+	t.LinkStatus = ""
+	t.LinkChecked = nil
+	t.LinkFailures = 0
+	t.Hidden = false
+	t.HiddenReason = ""
+}
+
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// durationSeconds converts the free form Duration of a video into seconds.
+// It understands plain seconds, clock notation (mm:ss or hh:mm:ss) and ISO
+// 8601 durations (PT1H2M3S), and returns 0 when the duration is unknown.
+func durationSeconds(d string) int {
+	d = strings.TrimSpace(d)
+	if m := isoDuration.FindStringSubmatch(strings.ToUpper(d)); m != nil {
+		seconds := 0
+		for i, unit := range []int{3600, 60, 1} {
+			n, _ := strconv.Atoi(m[i+1])
+			seconds += n * unit
+		}
+		return seconds
+	}
+
+	seconds := 0
+	for _, part := range strings.Split(d, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
 }